@@ -0,0 +1,108 @@
+// Command webhook-replay runs captured Bitbucket webhook payloads through the integration's webhook handler.
+//
+// Each payload is a json file with the event key and the body Bitbucket delivered:
+//
+//	{"event": "pullrequest:updated", "body": {"pullrequest": {...}, "repository": {...}}}
+//
+// The files, or directories of files, given as arguments are replayed in name order. Records are
+// written as json lines to the -out file and state is kept in the -state file so that it carries
+// over between runs.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pinpt/agent/v4/sdk"
+	"github.com/pinpt/bitbucket/internal"
+	"github.com/pinpt/bitbucket/internal/local"
+)
+
+type payload struct {
+	Event string          `json:"event"`
+	Body  json.RawMessage `json:"body"`
+}
+
+func main() {
+	configfn := flag.String("config", "config.json", "json file with the integration config, using the same keys as the agent's --set")
+	statefn := flag.String("state", "state.json", "json file used for integration state")
+	outfn := flag.String("out", "webhooks.json", "file to write the exported records to")
+	customerID := flag.String("customer-id", "1234", "customer id to use for the records")
+	integrationInstanceID := flag.String("integration-instance-id", "1234", "integration instance id to use for the records")
+	url := flag.String("url", "https://api.bitbucket.org/2.0", "bitbucket api url")
+	flag.Parse()
+
+	if err := run(*configfn, *statefn, *outfn, *customerID, *integrationInstanceID, *url, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func payloadFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(arg, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+func run(configfn, statefn, outfn, customerID, integrationInstanceID, url string, args []string) error {
+	files, err := payloadFiles(args)
+	if err != nil {
+		return fmt.Errorf("error finding payloads: %w", err)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no payload files given")
+	}
+	config, err := local.LoadConfig(configfn, nil)
+	if err != nil {
+		return err
+	}
+	state, err := local.NewState(statefn)
+	if err != nil {
+		return err
+	}
+	pipe, err := local.NewPipe(outfn)
+	if err != nil {
+		return err
+	}
+	defer pipe.Close()
+	logger := local.NewLogger(os.Stderr)
+	integration := internal.NewWithHTTPClient(local.NewHTTPClient(url, nil))
+	for _, fn := range files {
+		buf, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return fmt.Errorf("error reading payload: %w", err)
+		}
+		var p payload
+		if err := json.Unmarshal(buf, &p); err != nil {
+			return fmt.Errorf("error parsing payload %s: %w", fn, err)
+		}
+		sdk.LogInfo(logger, "replaying webhook", "file", fn, "event", p.Event)
+		if err := integration.ProcessWebHook(logger, config, state, pipe, customerID, integrationInstanceID, p.Event, p.Body); err != nil {
+			return fmt.Errorf("error replaying %s: %w", fn, err)
+		}
+	}
+	if err := pipe.Flush(); err != nil {
+		return err
+	}
+	return state.Flush()
+}
@@ -1,16 +1,27 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pinpt/agent/v4/sdk"
 )
 
 const updatedFormat = "2006-01-02T15:04:05.999999999-07:00"
 
+// rateLimitRetries is how many times a rate limited request is retried
+const rateLimitRetries = 3
+
+// defaultRetryAfter is how long to back off when a rate limited response doesn't say how long to wait
+const defaultRetryAfter = 30 * time.Second
+
 // API the api object
 type API struct {
 	client                sdk.HTTPClient
@@ -21,6 +32,30 @@ type API struct {
 	logger                sdk.Logger
 	creds                 sdk.WithHTTPOption
 	pipe                  sdk.Pipe
+	bots                  *BotDetector
+	issues                *IssueKeyExtractor
+	limiter               *Limiter
+	progress              *Progress
+	ctx                   context.Context
+	// rateLimitRetries is how many times a rate limited request is retried
+	rateLimitRetries int
+
+	// identities are the git identity users already sent, with the account they were associated to
+	identities   map[string]string
+	identitiesMu sync.Mutex
+	// committerLookup is true to get the committer of each commit from the commit api
+	committerLookup bool
+	// committers are the committers of commits already fetched, by sha
+	committers sync.Map
+	// members are the ref_ids of the members of each team
+	members sync.Map
+	// allMembers are the ref_ids of the members of every team, nil until they're synced or loaded from state
+	allMembers   map[string]bool
+	allMembersMu sync.Mutex
+	// participants are the pr participants already sent
+	participants sync.Map
+	// users are the users seen by account_id, for resolving mentions
+	users sync.Map
 }
 
 // New returns a new instance of API
@@ -34,34 +69,148 @@ func New(logger sdk.Logger, client sdk.HTTPClient, state sdk.State, pipe sdk.Pip
 		creds:                 creds,
 		state:                 state,
 		pipe:                  pipe,
+		ctx:                   context.Background(),
+		rateLimitRetries:      rateLimitRetries,
+		identities:            make(map[string]string),
 	}
 }
 
-func (a *API) paginate(endpoint string, params url.Values, callback func(buf json.RawMessage) error) error {
-	if params == nil {
-		params = url.Values{}
+// SetBotDetector sets how users are classified as bots, without one only the user type from bitbucket is used
+func (a *API) SetBotDetector(bots *BotDetector) {
+	a.bots = bots
+}
+
+// SetIssueKeyExtractor sets how jira issue keys are found, without one the defaults of NewIssueKeyExtractor are used
+func (a *API) SetIssueKeyExtractor(issues *IssueKeyExtractor) {
+	a.issues = issues
+}
+
+// SetLimiter sets the request budget, which can be shared by several APIs. Without one requests aren't limited
+// and prs are processed 10 at a time.
+func (a *API) SetLimiter(limiter *Limiter) {
+	a.limiter = limiter
+}
+
+// concurrency returns how many things, such as prs, to process at once
+func (a *API) concurrency() int {
+	if a.limiter == nil {
+		return 10
 	}
-	var page string
+	return a.limiter.Concurrency()
+}
+
+// SetCommitterLookup sets whether to get the committer of each pr commit from the commit api, which is one more
+// request per commit. Without it the author is used as the committer.
+func (a *API) SetCommitterLookup(lookup bool) {
+	a.committerLookup = lookup
+}
+
+// SetProgress sets the progress to count repo and pr totals in
+func (a *API) SetProgress(progress *Progress) {
+	a.progress = progress
+}
+
+// SetContext sets the context for requests, once it's done requests stop and return its error
+func (a *API) SetContext(ctx context.Context) {
+	a.ctx = ctx
+}
+
+// acquire waits for the limiter and returns the func to call once the request is done
+func (a *API) acquire() (func(), error) {
+	if err := a.ctx.Err(); err != nil {
+		return nil, err
+	}
+	if a.limiter == nil {
+		return func() {}, nil
+	}
+	if err := a.limiter.acquire(a.ctx); err != nil {
+		return nil, err
+	}
+	return a.limiter.release, nil
+}
+
+// request sends a request once the limiter allows it. A rate limited request backs off for as long as bitbucket asked
+// and is retried, the last error is returned once it's out of retries.
+func (a *API) request(method string, endpoint string, send func() (*sdk.HTTPResponse, error)) (*sdk.HTTPResponse, error) {
+	for attempt := 0; ; attempt++ {
+		release, err := a.acquire()
+		if err != nil {
+			return nil, err
+		}
+		res, err := send()
+		release()
+		err = a.requestError(method, endpoint, res, err)
+		var rerr *Error
+		if attempt == a.rateLimitRetries || !errors.As(err, &rerr) || !errors.Is(rerr, ErrRateLimited) {
+			return res, err
+		}
+		wait := rerr.RetryAfter
+		if wait == 0 {
+			wait = defaultRetryAfter
+		}
+		sdk.LogWarn(a.logger, "rate limited, backing off", "method", method, "endpoint", endpoint, "wait", wait.String())
+		if err := a.backoff(wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff waits d before the next request. With a limiter every request sharing it waits, since bitbucket rate limits
+// the whole account rather than one request.
+func (a *API) backoff(d time.Duration) error {
+	if a.limiter != nil {
+		a.limiter.pause(d)
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-a.ctx.Done():
+		return a.ctx.Err()
+	}
+}
+
+// withContext makes the request stop when the api context is done
+func (a *API) withContext() sdk.WithHTTPOption {
+	return func(opt *sdk.HTTPOptions) error {
+		opt.Request = opt.Request.WithContext(a.ctx)
+		return nil
+	}
+}
+
+// paginate calls callback with the values of each page, following the next link of each page as is
+func (a *API) paginate(endpoint string, params url.Values, callback func(buf json.RawMessage) error) error {
+	return a.paginateWithSize(endpoint, params, nil, callback)
+}
+
+// paginateWithSize is paginate which also calls size with the total number of records from the first page, when
+// bitbucket returns it
+func (a *API) paginateWithSize(endpoint string, params url.Values, size func(total int64), callback func(buf json.RawMessage) error) error {
+	var next string
 	for {
 		var res paginationResponse
-		if page != "" {
-			params.Set("page", page)
+		var err error
+		if next == "" {
+			_, err = a.get(endpoint, params, &res)
+		} else {
+			// next links have every param, which can be an opaque cursor instead of a page number
+			_, err = a.getURL(next, &res)
 		}
-		_, err := a.get(endpoint, params, &res)
 		if err != nil {
 			return err
 		}
+		if next == "" && size != nil && res.Size > 0 {
+			size(res.Size)
+		}
 		if err := callback(res.Values); err != nil {
 			return err
 		}
 		if res.Next == "" {
 			return nil
 		}
-		u, _ := url.Parse(res.Next)
-		page = u.Query().Get("page")
-		if page == "" {
-			return fmt.Errorf("no `page` in next. %v", u.String())
-		}
+		next = res.Next
 	}
 }
 
@@ -82,16 +231,44 @@ func (a *API) get(endpoint string, params url.Values, out interface{}) (*sdk.HTT
 	if params == nil {
 		params = url.Values{}
 	}
-	return a.client.Get(out, sdk.WithEndpoint(endpoint), sdk.WithGetQueryParameters(params), a.creds)
+	return a.request(http.MethodGet, endpoint, func() (*sdk.HTTPResponse, error) {
+		return a.client.Get(out, sdk.WithEndpoint(endpoint), sdk.WithGetQueryParameters(params), a.creds, a.withContext())
+	})
+}
+
+// getURL gets a full url returned by bitbucket, such as a next link
+func (a *API) getURL(rawurl string, out interface{}) (*sdk.HTTPResponse, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing url %s: %w", rawurl, err)
+	}
+	return a.request(http.MethodGet, u.Path, func() (*sdk.HTTPResponse, error) {
+		return a.client.Get(out, withURL(u), a.creds, a.withContext())
+	})
+}
+
+// withURL requests u instead of the client's url, it has to be on the same host so credentials aren't sent elsewhere
+func withURL(u *url.URL) sdk.WithHTTPOption {
+	return func(opt *sdk.HTTPOptions) error {
+		if u.Host != opt.Request.URL.Host {
+			return fmt.Errorf("url %s isn't on the api host %s", u, opt.Request.URL.Host)
+		}
+		opt.Request.URL = u
+		return nil
+	}
 }
 
 func (a *API) delete(endpoint string, out interface{}) (*sdk.HTTPResponse, error) {
-	return a.client.Delete(out, sdk.WithEndpoint(endpoint), a.creds)
+	return a.request(http.MethodDelete, endpoint, func() (*sdk.HTTPResponse, error) {
+		return a.client.Delete(out, sdk.WithEndpoint(endpoint), a.creds, a.withContext())
+	})
 }
 
 func (a *API) post(endpoint string, data interface{}, params url.Values, out interface{}) (*sdk.HTTPResponse, error) {
 	if params == nil {
 		params = url.Values{}
 	}
-	return a.client.Post(strings.NewReader(sdk.Stringify(data)), out, sdk.WithEndpoint(endpoint), sdk.WithGetQueryParameters(params), a.creds)
+	return a.request(http.MethodPost, endpoint, func() (*sdk.HTTPResponse, error) {
+		return a.client.Post(strings.NewReader(sdk.Stringify(data)), out, sdk.WithEndpoint(endpoint), sdk.WithGetQueryParameters(params), a.creds, a.withContext())
+	})
 }
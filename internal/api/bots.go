@@ -0,0 +1,91 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultBotNamePatterns match the display names and nicknames of common bots and service accounts, they're anchored
+// so a person whose name only contains one of them isn't a bot
+var DefaultBotNamePatterns = []string{
+	`\[bot\]$`,
+	`(^|[\s_-])bot$`,
+	`^renovate([\s_-]?bot)?$`,
+	`^dependabot(-preview)?$`,
+	`^snyk([\s_-]?bot)?$`,
+	`^(bitbucket[\s_-])?pipelines$`,
+}
+
+// BotDetector classifies users as bots, using the user type from bitbucket plus configured account ids, name patterns
+// and commit email domains
+type BotDetector struct {
+	accountIDs   map[string]bool
+	namePatterns []*regexp.Regexp
+	emailDomains []string
+}
+
+// NewBotDetector returns a BotDetector, namePatterns are case insensitive regular expressions matched against display
+// names and nicknames and emailDomains match commit emails in that domain or its subdomains
+func NewBotDetector(accountIDs, namePatterns, emailDomains []string) (*BotDetector, error) {
+	d := &BotDetector{accountIDs: make(map[string]bool)}
+	for _, id := range accountIDs {
+		d.accountIDs[id] = true
+	}
+	for _, pattern := range namePatterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bot name pattern %q: %w", pattern, err)
+		}
+		d.namePatterns = append(d.namePatterns, re)
+	}
+	for _, domain := range emailDomains {
+		d.emailDomains = append(d.emailDomains, strings.ToLower(strings.TrimPrefix(domain, "@")))
+	}
+	return d, nil
+}
+
+func (d *BotDetector) matchesName(names ...string) bool {
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		for _, re := range d.namePatterns {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsBot returns true if the atlassian user is a bot
+func (d *BotDetector) IsBot(user attlassianUser) bool {
+	if user.Type != "" && user.Type != "user" {
+		return true
+	}
+	if d == nil {
+		return false
+	}
+	return d.accountIDs[user.AccountID] || d.matchesName(user.DisplayName, user.Nickname)
+}
+
+// isBotIdentity returns true if the git identity from a commit is a bot
+func (d *BotDetector) isBotIdentity(identity gitIdentity) bool {
+	if d == nil {
+		return false
+	}
+	email := strings.ToLower(identity.Email)
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		domain := email[at+1:]
+		for _, botDomain := range d.emailDomains {
+			if domain == botDomain || strings.HasSuffix(domain, "."+botDomain) {
+				return true
+			}
+		}
+		if local := email[:at]; strings.Contains(local, "[bot]") || strings.HasSuffix(local, "-bot") {
+			return true
+		}
+	}
+	return d.matchesName(identity.Name)
+}
@@ -0,0 +1,38 @@
+package api
+
+import "testing"
+
+func TestDefaultBotNamePatterns(t *testing.T) {
+	bots, err := NewBotDetector(nil, DefaultBotNamePatterns, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		bot  bool
+	}{
+		{"renovate[bot]", true},
+		{"Renovate Bot", true},
+		{"renovate", true},
+		{"dependabot", true},
+		{"dependabot-preview", true},
+		{"snyk-bot", true},
+		{"Bitbucket Pipelines", true},
+		{"bitbucket-pipelines", true},
+		{"pipelines", true},
+		{"release-bot", true},
+		{"Alice Smith", false},
+		{"Sam Pipelines", false},
+		{"pipelines-team", false},
+		{"Renovated Kitchens", false},
+		{"Snyder", false},
+		{"Abbot", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bots.IsBot(attlassianUser{Type: "user", DisplayName: tt.name}); got != tt.bot {
+				t.Errorf("expected %q to be a bot %v, got %v", tt.name, tt.bot, got)
+			}
+		})
+	}
+}
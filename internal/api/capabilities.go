@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+// Capabilities is what the credentials are allowed to do in a workspace
+type Capabilities struct {
+	// Repo is the repo used to check the repo level capabilities, empty if the workspace has no repos
+	Repo             string `json:"repo,omitempty"`
+	CanReadPRs       bool   `json:"can_read_prs"`
+	CanReadMembers   bool   `json:"can_read_members"`
+	CanManageHooks   bool   `json:"can_manage_hooks"`
+	CanReadPipelines bool   `json:"can_read_pipelines"`
+}
+
+// Missing returns the names of the capabilities which are not allowed
+func (c Capabilities) Missing() []string {
+	var missing []string
+	if !c.CanReadPRs {
+		missing = append(missing, "can_read_prs")
+	}
+	if !c.CanReadMembers {
+		missing = append(missing, "can_read_members")
+	}
+	if !c.CanManageHooks {
+		missing = append(missing, "can_manage_hooks")
+	}
+	if !c.CanReadPipelines {
+		missing = append(missing, "can_read_pipelines")
+	}
+	return missing
+}
+
+// HookAccess is what the credentials say about managing webhooks, which reading the hooks of a repo can't tell
+type HookAccess struct {
+	// Scope is true if the credentials have the webhook scope, or don't say which scopes they have
+	Scope bool
+	// NeedsAdmin is true for a user, who also needs admin access to the repo
+	NeedsAdmin bool
+}
+
+// FetchRepoPermission returns the current user's permission on a repo, admin, write or read, or empty without access
+func (a *API) FetchRepoPermission(reponame string) (string, error) {
+	params := url.Values{}
+	params.Set("q", fmt.Sprintf(`repository.full_name="%s"`, reponame))
+	var res paginationResponse
+	if _, err := a.get(sdk.JoinURL("user", "permissions", "repositories"), params, &res); err != nil {
+		return "", err
+	}
+	var perms []repoPermissionResponse
+	if err := json.Unmarshal(res.Values, &perms); err != nil {
+		return "", err
+	}
+	for _, perm := range perms {
+		if perm.Repository.FullName == reponame {
+			return perm.Permission, nil
+		}
+	}
+	return "", nil
+}
+
+// probe returns false if the endpoint is denied or not found, any other error is returned
+func (a *API) probe(endpoint string) (bool, error) {
+	params := url.Values{}
+	params.Set("pagelen", "1")
+	var out interface{}
+	if _, err := a.get(endpoint, params, &out); err != nil {
+		var rerr *Error
+		if errors.As(err, &rerr) {
+			switch rerr.StatusCode {
+			case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+				return false, nil
+			}
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// firstRepo returns the full name of the most recently updated repo in the workspace
+func (a *API) firstRepo(workspaceSlug string) (string, error) {
+	params := url.Values{}
+	params.Set("pagelen", "1")
+	params.Set("sort", "-updated_on")
+	var res paginationResponse
+	if _, err := a.get(sdk.JoinURL("repositories", workspaceSlug), params, &res); err != nil {
+		return "", err
+	}
+	var repos []RepoResponse
+	if err := json.Unmarshal(res.Values, &repos); err != nil {
+		return "", err
+	}
+	if len(repos) == 0 {
+		return "", nil
+	}
+	return repos[0].FullName, nil
+}
+
+// FetchCapabilities checks what the credentials can do in a workspace, the repo level checks use reponame or the
+// most recently updated repo when it's empty
+func (a *API) FetchCapabilities(workspaceSlug string, reponame string, hooks HookAccess) (Capabilities, error) {
+	var caps Capabilities
+	var err error
+	if caps.CanReadMembers, err = a.probe(sdk.JoinURL("workspaces", workspaceSlug, "members")); err != nil {
+		return caps, fmt.Errorf("error checking members access: %w", err)
+	}
+	if reponame == "" {
+		if reponame, err = a.firstRepo(workspaceSlug); err != nil {
+			return caps, fmt.Errorf("error finding a repo to check: %w", err)
+		}
+		if reponame == "" {
+			sdk.LogDebug(a.logger, "no repos in workspace to check capabilities with", "workspace", workspaceSlug)
+			return caps, nil
+		}
+	}
+	caps.Repo = reponame
+	if caps.CanReadPRs, err = a.probe(sdk.JoinURL("repositories", reponame, "pullrequests")); err != nil {
+		return caps, fmt.Errorf("error checking pull request access: %w", err)
+	}
+	// creating webhooks needs the webhook scope and, for a user, admin access to the repo
+	caps.CanManageHooks = hooks.Scope
+	if hooks.Scope && hooks.NeedsAdmin {
+		perm, err := a.FetchRepoPermission(reponame)
+		if err != nil {
+			return caps, fmt.Errorf("error checking webhook access: %w", err)
+		}
+		caps.CanManageHooks = perm == "admin"
+	}
+	if caps.CanReadPipelines, err = a.probe(sdk.JoinURL("repositories", reponame, "pipelines")); err != nil {
+		return caps, fmt.Errorf("error checking pipelines access: %w", err)
+	}
+	return caps, nil
+}
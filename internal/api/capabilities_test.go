@@ -0,0 +1,45 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestFetchCapabilitiesHooks(t *testing.T) {
+	tests := []struct {
+		name       string
+		hooks      HookAccess
+		permission string
+		expected   bool
+	}{
+		{"admin", HookAccess{Scope: true, NeedsAdmin: true}, "admin", true},
+		{"write", HookAccess{Scope: true, NeedsAdmin: true}, "write", false},
+		{"missing scope", HookAccess{Scope: false, NeedsAdmin: true}, "admin", false},
+		{"token", HookAccess{Scope: true}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newServerTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/2.0/user/permissions/repositories":
+					if r.URL.Query().Get("q") != `repository.full_name="acme/api"` {
+						t.Errorf("unexpected permissions query %s", r.URL.RawQuery)
+					}
+					fmt.Fprintf(w, `{"values": [{"permission": %q, "repository": {"full_name": "acme/api"}}]}`, tt.permission)
+				case "/2.0/repositories/acme/api/hooks":
+					t.Error("expected the hooks not to be read")
+				default:
+					fmt.Fprint(w, `{"values": []}`)
+				}
+			})
+			caps, err := a.FetchCapabilities("acme", "acme/api", tt.hooks)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if caps.CanManageHooks != tt.expected {
+				t.Errorf("expected can_manage_hooks %v, got %v", tt.expected, caps.CanManageHooks)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+// These are matched with errors.Is against the errors returned from requests
+var (
+	// ErrNotFound is a not found response
+	ErrNotFound = errors.New("not found")
+	// ErrForbidden is a forbidden response, usually a missing permission or scope
+	ErrForbidden = errors.New("forbidden")
+	// ErrRateLimited is a too many requests response
+	ErrRateLimited = errors.New("rate limited")
+	// ErrTransport is a request which didn't get a response, such as a dns, connection or timeout error
+	ErrTransport = errors.New("transport error")
+)
+
+// Error is a failed request to bitbucket, it wraps the *sdk.HTTPError for error responses and the client's error
+// otherwise
+type Error struct {
+	Method   string
+	Endpoint string
+	// StatusCode is zero when there was no response
+	StatusCode int
+	// Message is the error message from bitbucket
+	Message string
+	// RetryAfter is how long bitbucket asked to wait before retrying a rate limited request, when it said
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("%s %s: %s", e.Method, e.Endpoint, e.Err)
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("%s %s: status %d: %s", e.Method, e.Endpoint, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s %s: status %d", e.Method, e.Endpoint, e.StatusCode)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches ErrNotFound, ErrForbidden, ErrRateLimited and ErrTransport
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrTransport:
+		return e.StatusCode == 0
+	}
+	return false
+}
+
+// requestError returns the error from a request as an *Error. Errors from before the request is sent, such as the
+// context being done, and errors decoding a successful response are returned as is.
+func (a *API) requestError(method string, endpoint string, res *sdk.HTTPResponse, err error) error {
+	if err == nil || a.ctx.Err() != nil {
+		return err
+	}
+	var rerr *sdk.HTTPError
+	if !errors.As(err, &rerr) {
+		if res != nil && res.StatusCode > 0 && res.StatusCode < http.StatusBadRequest {
+			return err
+		}
+		return &Error{Method: method, Endpoint: endpoint, Err: err}
+	}
+	e := &Error{Method: method, Endpoint: endpoint, StatusCode: rerr.StatusCode, Err: err}
+	if rerr.Body != nil {
+		buf, _ := ioutil.ReadAll(rerr.Body)
+		// put the body back for anything unwrapping the http error
+		rerr.Body = strings.NewReader(string(buf))
+		e.Message = errorMessage(buf)
+	}
+	if e.StatusCode == http.StatusTooManyRequests && res != nil && res.Headers != nil {
+		if secs, err := strconv.Atoi(res.Headers.Get("Retry-After")); err == nil {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		}
+	}
+	return e
+}
+
+// errorMessage returns the message from a bitbucket error body, or the start of the body when it isn't one
+func errorMessage(body []byte) string {
+	var res struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &res); err == nil && res.Error.Message != "" {
+		return res.Error.Message
+	}
+	msg := strings.TrimSpace(string(body))
+	if len(msg) > 200 {
+		msg = msg[:200]
+	}
+	return msg
+}
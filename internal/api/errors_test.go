@@ -0,0 +1,124 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pinpt/agent/v4/sdk"
+	"github.com/pinpt/bitbucket/internal/local"
+)
+
+func newServerTestAPI(t *testing.T, handler http.HandlerFunc) (*API, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	noauth := func(opt *sdk.HTTPOptions) error { return nil }
+	client := local.NewHTTPClient(server.URL+"/2.0", server.Client())
+	return New(local.NewLogger(ioutil.Discard), client, local.NewMemoryState(), local.NewMemoryPipe(), "1234", "5678", "bitbucket", noauth), server
+}
+
+func TestRequestErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		is         error
+		retryAfter time.Duration
+	}{
+		{"not found", http.StatusNotFound, ErrNotFound, 0},
+		{"forbidden", http.StatusForbidden, ErrForbidden, 0},
+		{"rate limited", http.StatusTooManyRequests, ErrRateLimited, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := newServerTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", "30")
+				w.WriteHeader(tt.status)
+				fmt.Fprint(w, `{"type": "error", "error": {"message": "nope"}}`)
+			})
+			// only the error is checked here, not the backoff
+			a.rateLimitRetries = 0
+			_, err := a.get("repositories/acme", nil, nil)
+			if !errors.Is(err, tt.is) {
+				t.Fatalf("expected %v, got %v", tt.is, err)
+			}
+			if errors.Is(err, ErrTransport) {
+				t.Error("expected an error response not to be a transport error")
+			}
+			var rerr *Error
+			if !errors.As(err, &rerr) || rerr.StatusCode != tt.status || rerr.Message != "nope" {
+				t.Fatalf("expected a %d error with the bitbucket message, got %v", tt.status, err)
+			}
+			if tt.status == http.StatusTooManyRequests && rerr.RetryAfter != tt.retryAfter {
+				t.Errorf("expected to retry after %v, got %v", tt.retryAfter, rerr.RetryAfter)
+			}
+			// the sdk error is still there for anything checking it
+			var herr *sdk.HTTPError
+			if !errors.As(err, &herr) || herr.StatusCode != tt.status {
+				t.Errorf("expected the sdk http error to be wrapped, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRequestTransportError(t *testing.T) {
+	a, server := newServerTestAPI(t, func(w http.ResponseWriter, r *http.Request) {})
+	server.Close()
+	_, err := a.get("repositories/acme", nil, nil)
+	if !errors.Is(err, ErrTransport) || errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected a transport error, got %v", err)
+	}
+	// used to panic on anything but an error response
+	if err := a.FetchUsers("acme", time.Time{}); !errors.Is(err, ErrTransport) {
+		t.Errorf("expected fetching users to return the transport error, got %v", err)
+	}
+	if _, err := a.fetchPullRequestCommits(PullRequestResponse{ID: 1}, "acme/api", "{repo}", time.Time{}); !errors.Is(err, ErrTransport) {
+		t.Errorf("expected fetching pr commits to return the transport error, got %v", err)
+	}
+}
+
+func TestPaginateFollowsNextURL(t *testing.T) {
+	var requests []string
+	var a *API
+	var server *httptest.Server
+	a, server = newServerTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RequestURI())
+		res := map[string]interface{}{"values": []string{r.URL.Query().Get("cursor")}}
+		if r.URL.Query().Get("cursor") == "" {
+			// a cursor instead of a page number, with a param the first request didn't have
+			res["next"] = server.URL + "/2.0/repositories/acme/api/commits?cursor=abc&pagelen=30"
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+	var values []string
+	err := a.paginate("repositories/acme/api/commits", nil, func(obj json.RawMessage) error {
+		var page []string
+		if err := json.Unmarshal(obj, &page); err != nil {
+			return err
+		}
+		values = append(values, page...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[1] != "abc" {
+		t.Errorf("expected both pages, got %v", values)
+	}
+	if len(requests) != 2 || requests[1] != "/2.0/repositories/acme/api/commits?cursor=abc&pagelen=30" {
+		t.Errorf("expected the next url to be followed as is, got %v", requests)
+	}
+}
+
+func TestPaginateRejectsOtherHosts(t *testing.T) {
+	a, _ := newServerTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"values": [], "next": "https://example.com/2.0/repositories/acme?page=2"}`)
+	})
+	if err := a.paginate("repositories/acme", nil, func(json.RawMessage) error { return nil }); err == nil {
+		t.Error("expected a next link on another host to be an error")
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import "strings"
+
+// paginationFields are the fields paginate uses from each page
+var paginationFields = []string{"next", "page", "size"}
+
+// userFields are the fields of a user used to send it and detect bots
+var userFields = []string{"type", "account_id", "display_name", "nickname", "links.html.href", "links.avatar.href"}
+
+var repoFields = []string{
+	"uuid", "full_name", "is_private", "language", "description", "mainbranch.name", "links.html.href", "updated_on",
+}
+
+var pullRequestFields = concatFields(
+	[]string{
+		"id", "title", "state", "description", "summary.html", "comment_count", "created_on", "updated_on",
+		"source.branch.name", "source.commit.hash", "destination.repository.full_name", "merge_commit.hash",
+		"links.html.href", "participants.role", "participants.approved", "participants.state", "participants.participated_on",
+	},
+	prefixFields("author", userFields),
+	prefixFields("closed_by", userFields),
+	prefixFields("participants.user", userFields),
+)
+
+var pullRequestCommentFields = concatFields(
+	[]string{"id", "content.raw", "content.html", "links.html.href", "created_on", "updated_on"},
+	prefixFields("user", userFields),
+)
+
+// pullRequestCommitFields doesn't have the committer since it's only returned by the commit api
+var pullRequestCommitFields = concatFields(
+	[]string{"hash", "message", "date", "links.html.href", "author.raw"},
+	prefixFields("author.user", userFields),
+)
+
+func prefixFields(prefix string, fields []string) []string {
+	prefixed := make([]string, len(fields))
+	for i, field := range fields {
+		prefixed[i] = prefix + "." + field
+	}
+	return prefixed
+}
+
+func concatFields(fields ...[]string) []string {
+	var all []string
+	for _, f := range fields {
+		all = append(all, f...)
+	}
+	return all
+}
+
+// pageFields returns the fields param for a page of records with only the fields given. Bitbucket returns every
+// field by default, including links to each related api, which are most of the response and aren't used.
+func pageFields(fields []string) string {
+	return strings.Join(append(append([]string{}, paginationFields...), prefixFields("values", fields)...), ",")
+}
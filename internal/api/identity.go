@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+// gitIdentity is the name and email of a raw git author or committer
+type gitIdentity struct {
+	Name  string
+	Email string
+}
+
+var rawIdentityRegexp = regexp.MustCompile(`^\s*(.*?)\s*<([^<>]*)>\s*$`)
+
+// parseRawIdentity parses a raw git identity like "Jane Doe <jane@example.com>", a raw value without an email is all name
+func parseRawIdentity(raw string) gitIdentity {
+	if m := rawIdentityRegexp.FindStringSubmatch(raw); m != nil {
+		return gitIdentity{Name: m[1], Email: strings.TrimSpace(m[2])}
+	}
+	return gitIdentity{Name: strings.TrimSpace(raw)}
+}
+
+// RefID will return the ref_id of the git identity user, which is keyed by email
+func (i gitIdentity) RefID() string {
+	return sdk.Hash(strings.ToLower(i.Email))
+}
+
+// identityToSend records a git identity as seen, it returns true the first time and when an atlassian account is found
+// for an identity which was sent without one, so the link is never replaced by a sighting without it
+func (a *API) identityToSend(refID string, userRefID string) bool {
+	a.identitiesMu.Lock()
+	defer a.identitiesMu.Unlock()
+	if linked, seen := a.identities[refID]; seen && (linked != "" || userRefID == "") {
+		return false
+	}
+	a.identities[refID] = userRefID
+	return true
+}
+
+// resolveCommitUser returns the ref_id a commit should be attributed to, the atlassian account when bitbucket linked
+// one and otherwise the git identity parsed from raw. The git identity user is sent once per export and is associated
+// to the atlassian account when there is one so unlinked commits from the same email can still be attributed.
+func (a *API) resolveCommitUser(raw string, user attlassianUser) (string, error) {
+	identity := parseRawIdentity(raw)
+	if identity.Email == "" {
+		return user.RefID(), nil
+	}
+	refID := identity.RefID()
+	if a.identityToSend(refID, user.RefID()) {
+		item := &sdk.SourceCodeUser{
+			CustomerID:            a.customerID,
+			Email:                 sdk.StringPointer(identity.Email),
+			RefID:                 refID,
+			RefType:               a.refType,
+			Member:                false,
+			Name:                  identity.Name,
+			Type:                  sdk.SourceCodeUserTypeHuman,
+			IntegrationInstanceID: sdk.StringPointer(a.integrationInstanceID),
+		}
+		if item.Name == "" {
+			item.Name = identity.Email
+		}
+		if a.bots.isBotIdentity(identity) || (user.RefID() != "" && a.bots.IsBot(user)) {
+			item.Type = sdk.SourceCodeUserTypeBot
+		}
+		if user.RefID() != "" {
+			item.AssociatedRefID = sdk.StringPointer(user.RefID())
+		}
+		if err := a.pipe.Write(item); err != nil {
+			return "", fmt.Errorf("error writing git user to pipe: %w", err)
+		}
+	}
+	if user.RefID() != "" {
+		return user.RefID(), nil
+	}
+	return refID, nil
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/pinpt/agent/v4/sdk"
+	"github.com/pinpt/bitbucket/internal/local"
+)
+
+func TestResolveCommitUserLinks(t *testing.T) {
+	a := newTestAPI()
+	pipe := a.pipe.(*local.MemoryPipe)
+	raw := "Jane Doe <jane@example.com>"
+	linked := attlassianUser{AccountID: "jane-id"}
+	sightings := []struct {
+		user     attlassianUser
+		expected string
+		sent     bool
+	}{
+		{attlassianUser{}, sdk.Hash("jane@example.com"), true},
+		{attlassianUser{}, sdk.Hash("jane@example.com"), false},
+		// the link is new so the identity is sent again with it
+		{linked, "jane-id", true},
+		// a later sighting without the link doesn't replace it
+		{attlassianUser{}, sdk.Hash("jane@example.com"), false},
+		{linked, "jane-id", false},
+	}
+	for i, s := range sightings {
+		pipe.Reset()
+		refID, err := a.resolveCommitUser(raw, s.user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refID != s.expected {
+			t.Errorf("sighting %d: expected ref_id %s, got %s", i, s.expected, refID)
+		}
+		var users []*sdk.SourceCodeUser
+		for _, obj := range pipe.Objects() {
+			if user, ok := obj.(*sdk.SourceCodeUser); ok {
+				users = append(users, user)
+			}
+		}
+		if s.sent != (len(users) == 1) {
+			t.Fatalf("sighting %d: expected sent %v, got %d users", i, s.sent, len(users))
+		}
+		if s.sent && s.user.AccountID != "" && (users[0].AssociatedRefID == nil || *users[0].AssociatedRefID != "jane-id") {
+			t.Errorf("sighting %d: expected the identity to be associated to jane-id", i)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package api
+
+import (
+	"regexp"
+	"strings"
+)
+
+// defaultIssueKeyRegexp matches any uppercase jira style key, such as PLAT-123, with a project key of 2 to 10
+// characters like jira allows
+var defaultIssueKeyRegexp = regexp.MustCompile(`(?:^|[^A-Za-z0-9])([A-Z][A-Z0-9_]{1,9}-[1-9][0-9]*)\b`)
+
+// defaultExcludedProjectKeys are uppercase words which are followed by a number in standards, encodings and versions,
+// such as UTF-8, SHA-256 or ISO-8601, and aren't jira projects. They're only excluded without configured project keys.
+var defaultExcludedProjectKeys = map[string]bool{
+	"AES":   true,
+	"ARM":   true,
+	"BASE":  true,
+	"COVID": true,
+	"CP":    true,
+	"CVE":   true,
+	"ECMA":  true,
+	"ES":    true,
+	"GPL":   true,
+	"GPLV":  true,
+	"HTTP":  true,
+	"IEEE":  true,
+	"IPV":   true,
+	"ISO":   true,
+	"LGPL":  true,
+	"MD":    true,
+	"PEP":   true,
+	"PKCS":  true,
+	"RFC":   true,
+	"RSA":   true,
+	"SHA":   true,
+	"SSL":   true,
+	"TLS":   true,
+	"UCS":   true,
+	"UTC":   true,
+	"UTF":   true,
+	"WIN":   true,
+}
+
+// IssueKeyExtractor finds jira issue keys in pr titles, branch names, descriptions and commit messages
+type IssueKeyExtractor struct {
+	re      *regexp.Regexp
+	exclude map[string]bool
+}
+
+// NewIssueKeyExtractor returns an IssueKeyExtractor for the jira project keys, which also matches lowercase keys like
+// branch names often have. Without project keys any uppercase key is matched except for common tokens like UTF-8.
+func NewIssueKeyExtractor(projectKeys []string) *IssueKeyExtractor {
+	if len(projectKeys) == 0 {
+		return &IssueKeyExtractor{re: defaultIssueKeyRegexp, exclude: defaultExcludedProjectKeys}
+	}
+	quoted := make([]string, len(projectKeys))
+	for i, key := range projectKeys {
+		quoted[i] = regexp.QuoteMeta(key)
+	}
+	return &IssueKeyExtractor{re: regexp.MustCompile(`(?i)(?:^|[^A-Za-z0-9])((?:` + strings.Join(quoted, "|") + `)-[1-9][0-9]*)\b`)}
+}
+
+// Extract returns the unique issue keys found in the texts in the order they were found
+func (e *IssueKeyExtractor) Extract(texts ...string) []string {
+	re, exclude := defaultIssueKeyRegexp, defaultExcludedProjectKeys
+	if e != nil {
+		re, exclude = e.re, e.exclude
+	}
+	var keys []string
+	found := make(map[string]bool)
+	for _, text := range texts {
+		for _, m := range re.FindAllStringSubmatch(text, -1) {
+			key := strings.ToUpper(m[1])
+			if exclude[key[:strings.LastIndex(key, "-")]] {
+				continue
+			}
+			if !found[key] {
+				found[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
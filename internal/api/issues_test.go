@@ -0,0 +1,39 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIssueKeyExtractor(t *testing.T) {
+	tests := []struct {
+		name        string
+		projectKeys []string
+		text        string
+		keys        []string
+	}{
+		{"key", nil, "PLAT-123 fix the build", []string{"PLAT-123"}},
+		{"several keys", nil, "PLAT-1, OPS-22 and PLAT-1 again", []string{"PLAT-1", "OPS-22"}},
+		{"in a branch", nil, "feature/PLAT-7-login", []string{"PLAT-7"}},
+		{"lowercase without project keys", nil, "feature/plat-7", nil},
+		{"encoding", nil, "read the file as UTF-8", nil},
+		{"hash", nil, "sign with SHA-256 instead of SHA-1", nil},
+		{"date format", nil, "dates are ISO-8601", nil},
+		{"protocol", nil, "use HTTP-2 and TLS-13", nil},
+		{"single letter project", nil, "rename X-1", nil},
+		{"project too long", nil, "ABCDEFGHIJK-1", nil},
+		{"project of 10", nil, "ABCDEFGHIJ-1", []string{"ABCDEFGHIJ-1"}},
+		{"zero", nil, "PLAT-0", nil},
+		{"part of a word", nil, "xPLAT-1", nil},
+		{"project keys", []string{"PLAT"}, "PLAT-1 and OPS-2", []string{"PLAT-1"}},
+		{"lowercase with project keys", []string{"PLAT"}, "feature/plat-7", []string{"PLAT-7"}},
+		{"excluded token as project key", []string{"UTF"}, "UTF-8", []string{"UTF-8"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if keys := NewIssueKeyExtractor(tt.projectKeys).Extract(tt.text); !reflect.DeepEqual(keys, tt.keys) {
+				t.Errorf("expected %v, got %v", tt.keys, keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is the request budget shared by everything in an export, it bounds the requests in flight and optionally
+// the request rate
+type Limiter struct {
+	sem      chan struct{}
+	interval time.Duration
+	next     time.Time
+	// paused is when requests can start again after a rate limited response
+	paused time.Time
+	mu     sync.Mutex
+}
+
+// NewLimiter returns a Limiter allowing concurrency requests at once and at most requestsPerHour, which is unlimited
+// when zero
+func NewLimiter(concurrency int, requestsPerHour int) *Limiter {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	l := &Limiter{sem: make(chan struct{}, concurrency)}
+	if requestsPerHour > 0 {
+		l.interval = time.Hour / time.Duration(requestsPerHour)
+	}
+	return l
+}
+
+// Concurrency returns the number of requests allowed at once
+func (l *Limiter) Concurrency() int {
+	return cap(l.sem)
+}
+
+// acquire blocks until a request is allowed or ctx is done, release must be called once the request is done. No
+// request is allowed while the limiter is paused.
+func (l *Limiter) acquire(ctx context.Context) error {
+	select {
+	case l.sem <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	l.mu.Lock()
+	now := time.Now()
+	var wait time.Duration
+	if l.interval > 0 {
+		if l.next.Before(now) {
+			l.next = now
+		}
+		wait = l.next.Sub(now)
+		l.next = l.next.Add(l.interval)
+	}
+	if paused := l.paused.Sub(now); paused > wait {
+		wait = paused
+	}
+	l.mu.Unlock()
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.release()
+		return ctx.Err()
+	}
+}
+
+func (l *Limiter) release() {
+	<-l.sem
+}
+
+// pause stops requests which haven't acquired the limiter yet from starting for d
+func (l *Limiter) pause(d time.Duration) {
+	l.mu.Lock()
+	if until := time.Now().Add(d); until.After(l.paused) {
+		l.paused = until
+	}
+	l.mu.Unlock()
+}
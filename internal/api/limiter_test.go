@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLimiterPause(t *testing.T) {
+	l := NewLimiter(2, 0)
+	l.pause(50 * time.Millisecond)
+	started := time.Now()
+	if err := l.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	l.release()
+	if waited := time.Since(started); waited < 50*time.Millisecond {
+		t.Errorf("expected the request to wait for the pause, waited %v", waited)
+	}
+	// a shorter pause doesn't cut a longer one short
+	l.pause(time.Hour)
+	l.pause(time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected the request to still be paused, got %v", err)
+	}
+}
+
+func TestRateLimitedRequestBacksOff(t *testing.T) {
+	var requests int
+	a, _ := newServerTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"type": "error", "error": {"message": "Rate limit for this resource has been exceeded"}}`)
+			return
+		}
+		fmt.Fprint(w, `{"size": 3}`)
+	})
+	a.SetLimiter(NewLimiter(1, 0))
+	started := time.Now()
+	count, err := a.getCount("repositories/acme", nil)
+	if err != nil {
+		t.Fatalf("expected the rate limited request to be retried, got %s", err)
+	}
+	if count != 3 || requests != 2 {
+		t.Errorf("expected a count of 3 after 2 requests, got %d after %d", count, requests)
+	}
+	if waited := time.Since(started); waited < time.Second {
+		t.Errorf("expected to back off for the retry after, waited %v", waited)
+	}
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+const bitbucketURL = "https://bitbucket.org"
+
+// bitbucketRefRegexp matches the bitbucket markdown extensions: @{account_id} mentions, owner/repo#12 and #12 pr
+// references, commit shas and emoji shortcodes
+var bitbucketRefRegexp = regexp.MustCompile(`@\{([^}]+)\}|\b([\w.-]+/[\w.-]+)#([0-9]+)\b|(^|[^\w&/])#([0-9]+)\b|\b([0-9a-f]{7,40})\b|:([a-z0-9_+-]+):`)
+
+var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+var mentionRegexp = regexp.MustCompile(`@\{([^}]+)\}`)
+
+// emoji are the most used bitbucket emoji shortcodes
+var emoji = map[string]string{
+	"+1":               "👍",
+	"-1":               "👎",
+	"thumbsup":         "👍",
+	"thumbsdown":       "👎",
+	"smile":            "😄",
+	"smiley":           "😃",
+	"grinning":         "😀",
+	"laughing":         "😆",
+	"wink":             "😉",
+	"slight_smile":     "🙂",
+	"confused":         "😕",
+	"cry":              "😢",
+	"heart":            "❤️",
+	"tada":             "🎉",
+	"rocket":           "🚀",
+	"fire":             "🔥",
+	"eyes":             "👀",
+	"white_check_mark": "✅",
+	"heavy_check_mark": "✔️",
+	"x":                "❌",
+	"warning":          "⚠️",
+	"bug":              "🐛",
+	"thinking":         "🤔",
+	"pray":             "🙏",
+	"clap":             "👏",
+	"ok_hand":          "👌",
+	"100":              "💯",
+}
+
+// ExtractMentions returns the unique account_ids @mentioned in raw bitbucket markdown, in the order they're mentioned
+func ExtractMentions(raw string) []string {
+	var mentions []string
+	found := make(map[string]bool)
+	for _, m := range mentionRegexp.FindAllStringSubmatch(raw, -1) {
+		if !found[m[1]] {
+			found[m[1]] = true
+			mentions = append(mentions, m[1])
+		}
+	}
+	return mentions
+}
+
+// renderBitbucketContent returns the html for a pr description or comment. The html bitbucket rendered is used when
+// there is one since it already resolves the bitbucket markdown extensions, otherwise the raw markdown is converted.
+func (a *API) renderBitbucketContent(renderedHTML, raw, reponame string) string {
+	body := renderedHTML
+	if body == "" {
+		body = a.postProcessMarkdown(sdk.ConvertMarkdownToHTML(raw), reponame)
+	}
+	return `<div class="source-bitbucket">` + body + "</div>"
+}
+
+// postProcessMarkdown resolves the bitbucket markdown extensions in html converted from markdown, text in links and
+// code is left alone
+func (a *API) postProcessMarkdown(body string, reponame string) string {
+	var sb strings.Builder
+	var skip int
+	var last int
+	for _, loc := range htmlTagRegexp.FindAllStringIndex(body, -1) {
+		text := body[last:loc[0]]
+		if skip > 0 {
+			sb.WriteString(text)
+		} else {
+			sb.WriteString(a.linkBitbucketRefs(text, reponame))
+		}
+		tag := strings.ToLower(body[loc[0]:loc[1]])
+		switch {
+		case strings.HasPrefix(tag, "<a ") || tag == "<a>" || strings.HasPrefix(tag, "<code") || strings.HasPrefix(tag, "<pre"):
+			skip++
+		case (tag == "</a>" || tag == "</code>" || tag == "</pre>") && skip > 0:
+			skip--
+		}
+		sb.WriteString(body[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	if skip > 0 {
+		sb.WriteString(body[last:])
+	} else {
+		sb.WriteString(a.linkBitbucketRefs(body[last:], reponame))
+	}
+	return sb.String()
+}
+
+// looksLikeSha returns true if a hex word has both letters and digits, which nearly every sha does, since a word of
+// only one or the other is usually a number or an english word like "defaced"
+func looksLikeSha(word string) bool {
+	return strings.ContainsAny(word, "0123456789") && strings.ContainsAny(word, "abcdef")
+}
+
+func link(href string, class string, text string) string {
+	return fmt.Sprintf(`<a href="%s" class="%s">%s</a>`, html.EscapeString(href), class, html.EscapeString(text))
+}
+
+func (a *API) linkBitbucketRefs(text string, reponame string) string {
+	var sb strings.Builder
+	var last int
+	for _, m := range bitbucketRefRegexp.FindAllStringSubmatchIndex(text, -1) {
+		sb.WriteString(text[last:m[0]])
+		match := text[m[0]:m[1]]
+		group := func(i int) string {
+			if m[i*2] < 0 {
+				return ""
+			}
+			return text[m[i*2]:m[i*2+1]]
+		}
+		switch {
+		case group(1) != "":
+			accountID := group(1)
+			name, href := accountID, bitbucketURL+"/"+url.PathEscape("{"+accountID+"}")+"/"
+			if user, ok := a.users.Load(accountID); ok {
+				u := user.(attlassianUser)
+				name = u.DisplayName
+				if u.Links.HTML.Href != "" {
+					href = u.Links.HTML.Href
+				}
+			}
+			sb.WriteString(link(href, "mention", "@"+name))
+		case group(2) != "":
+			sb.WriteString(link(bitbucketURL+"/"+group(2)+"/pull-requests/"+group(3), "pull-request", match))
+		case group(5) != "":
+			sb.WriteString(group(4))
+			sb.WriteString(link(bitbucketURL+"/"+reponame+"/pull-requests/"+group(5), "pull-request", "#"+group(5)))
+		case group(6) != "":
+			sha := group(6)
+			if looksLikeSha(sha) {
+				sb.WriteString(link(bitbucketURL+"/"+reponame+"/commits/"+sha, "commit", sha[:7]))
+			} else {
+				sb.WriteString(match)
+			}
+		case group(7) != "":
+			if e, ok := emoji[group(7)]; ok {
+				sb.WriteString(e)
+			} else {
+				sb.WriteString(match)
+			}
+		default:
+			sb.WriteString(match)
+		}
+		last = m[1]
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
@@ -0,0 +1,81 @@
+package api
+
+import "testing"
+
+func TestPostProcessMarkdown(t *testing.T) {
+	a := newTestAPI()
+	a.users.Store("bob-id", attlassianUser{AccountID: "bob-id", DisplayName: "Bob Jones"})
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			"mention",
+			`<p>thanks @{bob-id}</p>`,
+			`<p>thanks <a href="https://bitbucket.org/%7Bbob-id%7D/" class="mention">@Bob Jones</a></p>`,
+		},
+		{
+			"unknown mention",
+			`<p>@{carol-id}</p>`,
+			`<p><a href="https://bitbucket.org/%7Bcarol-id%7D/" class="mention">@carol-id</a></p>`,
+		},
+		{
+			"pr in the repo",
+			`<p>see #12</p>`,
+			`<p>see <a href="https://bitbucket.org/acme/api/pull-requests/12" class="pull-request">#12</a></p>`,
+		},
+		{
+			"pr in another repo",
+			`<p>see acme/web#3</p>`,
+			`<p>see <a href="https://bitbucket.org/acme/web/pull-requests/3" class="pull-request">acme/web#3</a></p>`,
+		},
+		{
+			"html entity",
+			`<p>a &#39;quote&#39;</p>`,
+			`<p>a &#39;quote&#39;</p>`,
+		},
+		{
+			"sha",
+			`<p>fixed in a1b2c3d4e5f6</p>`,
+			`<p>fixed in <a href="https://bitbucket.org/acme/api/commits/a1b2c3d4e5f6" class="commit">a1b2c3d</a></p>`,
+		},
+		{
+			"number",
+			`<p>order 12345678</p>`,
+			`<p>order 12345678</p>`,
+		},
+		{
+			"hex word",
+			`<p>the page was defaced, see the facade</p>`,
+			`<p>the page was defaced, see the facade</p>`,
+		},
+		{
+			"too short for a sha",
+			`<p>a1b2c3</p>`,
+			`<p>a1b2c3</p>`,
+		},
+		{
+			"emoji",
+			`<p>done :tada: :unknown:</p>`,
+			`<p>done 🎉 :unknown:</p>`,
+		},
+		{
+			"inside a link",
+			`<p><a href="https://example.com">#12 a1b2c3d4e5f6</a></p>`,
+			`<p><a href="https://example.com">#12 a1b2c3d4e5f6</a></p>`,
+		},
+		{
+			"inside code",
+			`<pre><code>@{bob-id} #12 a1b2c3d4e5f6</code></pre>`,
+			`<pre><code>@{bob-id} #12 a1b2c3d4e5f6</code></pre>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := a.postProcessMarkdown(tt.html, "acme/api"); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
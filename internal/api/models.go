@@ -54,6 +54,23 @@ type WorkSpacesResponse struct {
 	UUID string `json:"uuid"`
 }
 
+type workspacePermissionResponse struct {
+	Permission string `json:"permission"`
+	Workspace  struct {
+		Name string `json:"name"`
+		Slug string `json:"slug"`
+		UUID string `json:"uuid"`
+	} `json:"workspace"`
+}
+
+type repoPermissionResponse struct {
+	Permission string `json:"permission"`
+	Repository struct {
+		FullName string `json:"full_name"`
+		UUID     string `json:"uuid"`
+	} `json:"repository"`
+}
+
 // RepoResponse repo response
 type RepoResponse struct {
 	CreatedOn   time.Time `json:"created_on"`
@@ -172,6 +189,8 @@ type attlassianUser struct {
 	Nickname  string `json:"nickname"`
 	Type      string `json:"type"`
 	AccountID string `json:"account_id"`
+	// AccountStatus is active, inactive or closed
+	AccountStatus string `json:"account_status"`
 }
 
 type userResponse struct {
@@ -209,6 +228,7 @@ type MyUser struct {
 	DisplayName   string    `json:"display_name"`
 	Nickname      string    `json:"nickname"`
 	Type          string    `json:"type"`
+	Username      string    `json:"username"`
 	UUID          string    `json:"uuid"`
 }
 
@@ -294,13 +314,11 @@ type PullRequestResponse struct {
 		Hash string `json:"hash"`
 	} `json:"merge_commit"`
 	Participants []struct {
-		Role           string    `json:"role"`
-		Approved       bool      `json:"approved"`
-		ParticipatedOn time.Time `json:"participated_on"`
-		User           struct {
-			AccountID string `json:"account_id"`
-			UUID      string `json:"uuid"`
-		} `json:"user"`
+		Role           string         `json:"role"`
+		Approved       bool           `json:"approved"`
+		State          string         `json:"state"`
+		ParticipatedOn time.Time      `json:"participated_on"`
+		User           attlassianUser `json:"user"`
 	} `json:"participants"`
 	Reason string `json:"reason"`
 	Source struct {
@@ -386,15 +404,27 @@ type PullRequestCommentResponse struct {
 	UpdatedOn time.Time      `json:"updated_on"`
 	User      attlassianUser `json:"user"`
 }
+
+// ChangesRequestResponse is the changes request sent with the changes request webhooks
+type ChangesRequestResponse struct {
+	Date time.Time      `json:"date"`
+	User attlassianUser `json:"user"`
+}
+
+// commitUserResponse is the author or committer of a commit, user is only set when bitbucket could link the email to an account
+type commitUserResponse struct {
+	Raw  string         `json:"raw"`
+	Type string         `json:"type"`
+	User attlassianUser `json:"user"`
+}
+
 type prCommitResponse struct {
-	Author struct {
-		Raw  string         `json:"raw"`
-		Type string         `json:"type"`
-		User attlassianUser `json:"user"`
-	} `json:"author"`
-	Date  time.Time `json:"date"`
-	Hash  string    `json:"hash"`
-	Links struct {
+	Author commitUserResponse `json:"author"`
+	// Committer isn't returned in commit lists, it's filled in from the commit endpoint when bitbucket has it
+	Committer *commitUserResponse `json:"committer"`
+	Date      time.Time           `json:"date"`
+	Hash      string              `json:"hash"`
+	Links     struct {
 		Approve struct {
 			Href string `json:"href"`
 		} `json:"approve"`
@@ -458,7 +488,7 @@ type prCommitResponse struct {
 
 type webhookPayload struct {
 	Active      bool     `json:"active"`
-	CreatorID   string   `json:"creator_id"`
+	CreatorID   string   `json:"creator_id,omitempty"`
 	Description string   `json:"description"`
 	Events      []string `json:"events"`
 	SubjectKey  string   `json:"subject_key"`
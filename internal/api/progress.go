@@ -0,0 +1,143 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// Progress counts how far along an export is, it's safe for concurrent use and its methods do nothing when nil
+type Progress struct {
+	started time.Time
+
+	workspaces     int64
+	workspacesDone int64
+	repos          int64
+	reposDone      int64
+	reposCounted   bool
+	// prs and prsDone are by repo ref_id and backfill window, so the prs of a window which is fetched again, such as
+	// when its repo is retried, are only counted once while each older window adds its own
+	prs     map[string]int64
+	prsDone map[string]int64
+	mu      sync.Mutex
+}
+
+// ProgressSnapshot is the progress at a point in time, ETA is zero until it can be estimated
+type ProgressSnapshot struct {
+	WorkSpaces       int64         `json:"workspaces"`
+	WorkSpacesDone   int64         `json:"workspaces_done"`
+	Repos            int64         `json:"repos"`
+	ReposDone        int64         `json:"repos_done"`
+	PullRequests     int64         `json:"prs"`
+	PullRequestsDone int64         `json:"prs_done"`
+	Elapsed          time.Duration `json:"elapsed"`
+	ETA              time.Duration `json:"eta"`
+	At               time.Time     `json:"at"`
+}
+
+// NewProgress returns a Progress for an export started at started
+func NewProgress(started time.Time) *Progress {
+	return &Progress{started: started, prs: make(map[string]int64), prsDone: make(map[string]int64)}
+}
+
+// SetWorkSpaces sets the number of workspaces to export
+func (p *Progress) SetWorkSpaces(total int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.workspaces = int64(total)
+	p.mu.Unlock()
+}
+
+// WorkSpaceDone counts a workspace whose repos have all been listed
+func (p *Progress) WorkSpaceDone() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.workspacesDone++
+	p.mu.Unlock()
+}
+
+// CountRepos adds the repos of a workspace counted before listing them, after which the totals returned while
+// listing repos are ignored
+func (p *Progress) CountRepos(total int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.repos += total
+	p.reposCounted = true
+	p.mu.Unlock()
+}
+
+// addListedRepos adds the total returned when listing repos, unless the repos were counted up front
+func (p *Progress) addListedRepos(total int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if !p.reposCounted {
+		p.repos += total
+	}
+	p.mu.Unlock()
+}
+
+// RepoDone counts a repo which was exported, skipped or failed
+func (p *Progress) RepoDone() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.reposDone++
+	p.mu.Unlock()
+}
+
+// addPullRequests sets the total prs of a repo's window the first time it's listed
+func (p *Progress) addPullRequests(window string, total int64) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	if _, ok := p.prs[window]; !ok {
+		p.prs[window] = total
+	}
+	p.mu.Unlock()
+}
+
+func (p *Progress) pullRequestsDone(window string, n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.prsDone[window] += int64(n)
+	p.mu.Unlock()
+}
+
+// Snapshot returns the progress so far, the ETA is estimated from the share of repos done
+func (p *Progress) Snapshot() ProgressSnapshot {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	s := ProgressSnapshot{
+		WorkSpaces:     p.workspaces,
+		WorkSpacesDone: p.workspacesDone,
+		Repos:          p.repos,
+		ReposDone:      p.reposDone,
+		Elapsed:        now.Sub(p.started),
+		At:             now,
+	}
+	for window, total := range p.prs {
+		s.PullRequests += total
+		// prs fetched again are only done once
+		if done := p.prsDone[window]; done < total {
+			s.PullRequestsDone += done
+		} else {
+			s.PullRequestsDone += total
+		}
+	}
+	if s.ReposDone > 0 && s.Repos > s.ReposDone {
+		s.ETA = time.Duration(float64(s.Elapsed) / float64(s.ReposDone) * float64(s.Repos-s.ReposDone))
+	}
+	return s
+}
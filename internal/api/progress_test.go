@@ -0,0 +1,28 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProgressCountsPullRequestsOncePerWindow(t *testing.T) {
+	p := NewProgress(time.Now())
+	// the first attempt at repo a fails after a page, then it's retried
+	p.addPullRequests("a", 100)
+	p.pullRequestsDone("a", 50)
+	p.addPullRequests("a", 100)
+	p.pullRequestsDone("a", 50)
+	p.pullRequestsDone("a", 50)
+	// an older backfill window of repo a adds its own total, and isn't done yet
+	p.addPullRequests("a older", 30)
+	p.addPullRequests("b", 10)
+	p.pullRequestsDone("b", 10)
+	s := p.Snapshot()
+	if s.PullRequests != 140 || s.PullRequestsDone != 110 {
+		t.Errorf("expected 110 of 140 prs, got %d of %d", s.PullRequestsDone, s.PullRequests)
+	}
+	p.pullRequestsDone("a older", 30)
+	if s := p.Snapshot(); s.PullRequestsDone != 140 {
+		t.Errorf("expected 140 of 140 prs, got %d of %d", s.PullRequestsDone, s.PullRequests)
+	}
+}
@@ -9,7 +9,55 @@ import (
 	"github.com/pinpt/agent/v4/sdk"
 )
 
-func (a *API) fetchPullRequestComments(pr PullRequestResponse, reponame string, repoRefID string, updated time.Time) error {
+// syncPullRequestComments fetches the comments of a pr unless none were added, edited or deleted since the last
+// export, and returns its most recently updated comment to save in the fingerprint
+func (a *API) syncPullRequestComments(pr PullRequestResponse, fingerprint *prFingerprint, reponame string, repoRefID string, updated time.Time) (commentMarker, error) {
+	if !fingerprint.commentCountChanged(pr) {
+		last, err := a.fetchLastComment(pr, reponame)
+		if err != nil {
+			return commentMarker{}, err
+		}
+		if last.equal(fingerprint.LastComment) {
+			sdk.LogDebug(a.logger, "skipping unchanged pr comments", "repo", reponame, "pr", pr.ID)
+			return last, nil
+		}
+		if _, err := a.fetchPullRequestComments(pr, reponame, repoRefID, updated); err != nil {
+			return commentMarker{}, err
+		}
+		return last, nil
+	}
+	last, err := a.fetchPullRequestComments(pr, reponame, repoRefID, updated)
+	if err != nil {
+		return commentMarker{}, err
+	}
+	if last.ID == 0 {
+		// nothing was updated since the last export
+		last = fingerprint.lastComment()
+	}
+	return last, nil
+}
+
+// fetchLastComment returns the most recently updated comment of the pr
+func (a *API) fetchLastComment(pr PullRequestResponse, reponame string) (commentMarker, error) {
+	endpoint := sdk.JoinURL("repositories", reponame, "pullrequests", fmt.Sprint(pr.ID), "comments")
+	params := url.Values{}
+	params.Set("pagelen", "1")
+	params.Set("sort", "-updated_on")
+	params.Set("fields", "values.id,values.updated_on")
+	var res struct {
+		Values []commentMarker `json:"values"`
+	}
+	if _, err := a.get(endpoint, params, &res); err != nil {
+		return commentMarker{}, fmt.Errorf("error checking for updated pr comments: %w", err)
+	}
+	if len(res.Values) == 0 {
+		return commentMarker{}, nil
+	}
+	return res.Values[0], nil
+}
+
+// fetchPullRequestComments fetches the comments of the pr updated after updated and returns the most recently updated
+func (a *API) fetchPullRequestComments(pr PullRequestResponse, reponame string, repoRefID string, updated time.Time) (commentMarker, error) {
 	sdk.LogDebug(a.logger, "fetching pull requests comments", "repo", reponame)
 	endpoint := sdk.JoinURL("repositories", reponame, "pullrequests", fmt.Sprint(pr.ID), "comments")
 	params := url.Values{}
@@ -17,14 +65,22 @@ func (a *API) fetchPullRequestComments(pr PullRequestResponse, reponame string,
 		params.Set("q", `updated_on > `+updated.Format(updatedFormat))
 	}
 	params.Set("sort", "-updated_on")
+	params.Set("fields", pageFields(pullRequestCommentFields))
 	var count int
+	var last commentMarker
 	err := a.paginate(endpoint, params, func(obj json.RawMessage) error {
 		rawResponse := []PullRequestCommentResponse{}
 		if err := json.Unmarshal(obj, &rawResponse); err != nil {
 			return err
 		}
+		if count == 0 && len(rawResponse) > 0 {
+			last = commentMarker{rawResponse[0].ID, rawResponse[0].UpdatedOn}
+		}
 		for _, rcomment := range rawResponse {
-			if err := a.pipe.Write(ConvertPullRequestComment(rcomment, repoRefID, fmt.Sprint(pr.ID), a.customerID, a.integrationInstanceID, a.refType)); err != nil {
+			if err := a.SendCommentUsers(rcomment); err != nil {
+				return err
+			}
+			if err := a.pipe.Write(a.ConvertPullRequestComment(rcomment, reponame, repoRefID, fmt.Sprint(pr.ID))); err != nil {
 				return fmt.Errorf("error writing pr comment to pipe: %w", err)
 			}
 		}
@@ -32,24 +88,32 @@ func (a *API) fetchPullRequestComments(pr PullRequestResponse, reponame string,
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("error getting pr comments. err %v", err)
+		return commentMarker{}, fmt.Errorf("error getting pr comments. err %w", err)
 	}
 	sdk.LogDebug(a.logger, "finished fetching pull request comments", "repo", reponame, "count", count)
-	return nil
+	return last, nil
+}
+
+// SendCommentUsers sends the user records for the author of a comment and the users it mentions which aren't members
+func (a *API) SendCommentUsers(raw PullRequestCommentResponse) error {
+	if err := a.sendParticipantUser(raw.User); err != nil {
+		return err
+	}
+	return a.sendMentionedUsers(raw.Content.Raw)
 }
 
 // ConvertPullRequestComment converts from raw response to pinpoint object
-func ConvertPullRequestComment(raw PullRequestCommentResponse, repoRefID, prid, customerID, integrationInstanceID, refType string) *sdk.SourceCodePullRequestComment {
+func (a *API) ConvertPullRequestComment(raw PullRequestCommentResponse, reponame, repoRefID, prid string) *sdk.SourceCodePullRequestComment {
 	item := &sdk.SourceCodePullRequestComment{
 		Active:                true,
-		CustomerID:            customerID,
-		RefType:               refType,
-		IntegrationInstanceID: sdk.StringPointer(integrationInstanceID),
+		CustomerID:            a.customerID,
+		RefType:               a.refType,
+		IntegrationInstanceID: sdk.StringPointer(a.integrationInstanceID),
 		RefID:                 fmt.Sprint(raw.ID),
 		URL:                   raw.Links.HTML.Href,
-		RepoID:                sdk.NewSourceCodeRepoID(customerID, repoRefID, refType),
-		PullRequestID:         sdk.NewSourceCodePullRequestID(customerID, prid, refType, repoRefID),
-		Body:                  `<div class="source-bitbucket">` + sdk.ConvertMarkdownToHTML(raw.Content.Raw) + "</div>",
+		RepoID:                sdk.NewSourceCodeRepoID(a.customerID, repoRefID, a.refType),
+		PullRequestID:         sdk.NewSourceCodePullRequestID(a.customerID, prid, a.refType, repoRefID),
+		Body:                  a.renderBitbucketContent(raw.Content.HTML, raw.Content.Raw, reponame),
 		UserRefID:             raw.User.RefID(),
 	}
 	sdk.ConvertTimeToDateModel(raw.UpdatedOn, &item.UpdatedDate)
@@ -2,8 +2,8 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
 	"net/url"
 	"time"
 
@@ -38,6 +38,7 @@ func (a *API) fetchPullRequestCommits(pr PullRequestResponse, reponame string, r
 		params.Set("q", `updated_on > `+updated.Format(updatedFormat))
 	}
 	params.Set("sort", "-updated_on")
+	params.Set("fields", pageFields(pullRequestCommitFields))
 	var count int
 	var shas []string
 	err := a.paginate(endpoint, params, func(obj json.RawMessage) error {
@@ -45,7 +46,7 @@ func (a *API) fetchPullRequestCommits(pr PullRequestResponse, reponame string, r
 		if err := json.Unmarshal(obj, &rawResponse); err != nil {
 			return err
 		}
-		commitShas, err := a.sendPullRequestCommits(rawResponse, repoRefID, fmt.Sprint(pr.ID))
+		commitShas, err := a.sendPullRequestCommits(rawResponse, reponame, repoRefID, fmt.Sprint(pr.ID))
 		if err != nil {
 			return fmt.Errorf("error sending pr commits: %w", err)
 		}
@@ -54,19 +55,47 @@ func (a *API) fetchPullRequestCommits(pr PullRequestResponse, reponame string, r
 		return nil
 	})
 	if err != nil {
-		rerr := err.(*sdk.HTTPError)
 		// not found means no commits
-		if rerr.StatusCode == http.StatusNotFound {
+		if errors.Is(err, ErrNotFound) {
 			sdk.LogDebug(a.logger, "no commits found for this PR", "repo", reponame, "pr", pr.ID)
 		} else {
-			return nil, fmt.Errorf("error fetching pr commits. err %v", err)
+			return nil, fmt.Errorf("error fetching pr commits. err %w", err)
 		}
 	}
 	sdk.LogDebug(a.logger, "finished fetching pull request commits", "repo", reponame, "count", count)
 	return shas, nil
 }
 
-func (a *API) sendPullRequestCommits(raw []prCommitResponse, repoRefID, prRefID string) ([]string, error) {
+// fetchCommitter returns the committer of a commit, falling back to the author when bitbucket doesn't have a separate
+// committer, as is the case for commits which weren't rebased or cherry-picked. Commit lists don't have the committer
+// so it's only looked up from the commit api when SetCommitterLookup is on, otherwise and when the commit can't be
+// read the author is used.
+func (a *API) fetchCommitter(reponame string, commit prCommitResponse) (commitUserResponse, error) {
+	if commit.Committer == nil && a.committerLookup {
+		if cached, ok := a.committers.Load(commit.Hash); ok {
+			return cached.(commitUserResponse), nil
+		}
+		var detail prCommitResponse
+		if _, err := a.get(sdk.JoinURL("repositories", reponame, "commit", commit.Hash), nil, &detail); err != nil {
+			if !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrForbidden) {
+				return commitUserResponse{}, fmt.Errorf("error fetching commit %s: %w", commit.Hash, err)
+			}
+			// commits from forks or which were deleted can't be read
+			sdk.LogDebug(a.logger, "can't read commit, using the author as committer", "repo", reponame, "sha", commit.Hash, "err", err)
+		}
+		commit.Committer = detail.Committer
+	}
+	committer := commit.Author
+	if commit.Committer != nil && commit.Committer.Raw != "" {
+		committer = *commit.Committer
+	}
+	if a.committerLookup {
+		a.committers.Store(commit.Hash, committer)
+	}
+	return committer, nil
+}
+
+func (a *API) sendPullRequestCommits(raw []prCommitResponse, reponame, repoRefID, prRefID string) ([]string, error) {
 	if len(raw) == 0 {
 		return nil, nil
 	}
@@ -74,6 +103,23 @@ func (a *API) sendPullRequestCommits(raw []prCommitResponse, repoRefID, prRefID
 	shas := make([]string, len(raw))
 	for i, rccommit := range raw {
 		shas[i] = rccommit.Hash
+		authorRefID, err := a.resolveCommitUser(rccommit.Author.Raw, rccommit.Author.User)
+		if err != nil {
+			return nil, err
+		}
+		committer, err := a.fetchCommitter(reponame, rccommit)
+		if err != nil {
+			return nil, err
+		}
+		committerRefID, err := a.resolveCommitUser(committer.Raw, committer.User)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range []attlassianUser{rccommit.Author.User, committer.User} {
+			if err := a.sendParticipantUser(user); err != nil {
+				return nil, err
+			}
+		}
 		item := &sdk.SourceCodePullRequestCommit{
 			Active:                true,
 			CustomerID:            a.customerID,
@@ -84,10 +130,12 @@ func (a *API) sendPullRequestCommits(raw []prCommitResponse, repoRefID, prRefID
 			PullRequestID:         sdk.NewSourceCodePullRequestID(a.customerID, prRefID, a.refType, repoRefID),
 			Sha:                   rccommit.Hash,
 			Message:               rccommit.Message,
-			AuthorRefID:           rccommit.Author.User.RefID(),
-			CommitterRefID:        rccommit.Author.User.RefID(),
+			IssueKeys:             a.issues.Extract(rccommit.Message),
+			AuthorRefID:           authorRefID,
+			CommitterRefID:        committerRefID,
 			IntegrationInstanceID: sdk.StringPointer(a.integrationInstanceID),
 		}
+		// the commit model has no committed date
 		sdk.ConvertTimeToDateModel(rccommit.Date, &item.CreatedDate)
 		if err := a.pipe.Write(item); err != nil {
 			return nil, fmt.Errorf("error writing pr commit to pipe: %w", err)
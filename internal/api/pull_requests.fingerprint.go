@@ -0,0 +1,119 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+// prFingerprint is what the comments and commits of a pr depend on, saved in state after each export so an
+// incremental export only fetches the ones which changed. Prs whose updated_on didn't change aren't returned by an
+// incremental export at all. An approval or title change costs one small request for the last comment instead of
+// fetching every comment and commit again.
+type prFingerprint struct {
+	CommentCount int64 `json:"comment_count"`
+	// LastComment is the most recently updated comment, which changes when a comment is edited even if the count doesn't
+	LastComment commentMarker `json:"last_comment"`
+	SourceHash  string        `json:"source_hash"`
+	// CommitShas are sent with the pr when its commits aren't fetched
+	CommitShas []string `json:"commit_shas"`
+}
+
+// commentMarker is the id and updated_on of a pr's most recently updated comment, it's zero when the pr has none
+type commentMarker struct {
+	ID        int64     `json:"id"`
+	UpdatedOn time.Time `json:"updated_on"`
+}
+
+func (m commentMarker) equal(other commentMarker) bool {
+	return m.ID == other.ID && m.UpdatedOn.Equal(other.UpdatedOn)
+}
+
+func newPRFingerprint(pr PullRequestResponse, lastComment commentMarker, commitShas []string) prFingerprint {
+	return prFingerprint{
+		CommentCount: pr.CommentCount,
+		LastComment:  lastComment,
+		SourceHash:   pr.Source.Commit.Hash,
+		CommitShas:   commitShas,
+	}
+}
+
+// commentCountChanged returns true if a comment was added or removed, the last comment has to be checked for edits
+func (f *prFingerprint) commentCountChanged(pr PullRequestResponse) bool {
+	return f == nil || f.CommentCount != pr.CommentCount
+}
+
+func (f *prFingerprint) lastComment() commentMarker {
+	if f == nil {
+		return commentMarker{}
+	}
+	return f.LastComment
+}
+
+// commitsChanged returns true if the pr's source branch was pushed to
+func (f *prFingerprint) commitsChanged(pr PullRequestResponse) bool {
+	return f == nil || f.SourceHash != pr.Source.Commit.Hash
+}
+
+func (f *prFingerprint) commitShas() []string {
+	if f == nil {
+		return nil
+	}
+	return f.CommitShas
+}
+
+// mergeCommitShas adds the shas from the last export to the ones fetched since, which are newest first
+func (f *prFingerprint) mergeCommitShas(shas []string) []string {
+	seen := make(map[string]bool)
+	merged := make([]string, 0, len(shas)+len(f.commitShas()))
+	for _, sha := range append(append([]string{}, shas...), f.commitShas()...) {
+		if !seen[sha] {
+			seen[sha] = true
+			merged = append(merged, sha)
+		}
+	}
+	return merged
+}
+
+// prFingerprintExpiry is how long the fingerprint of a pr which isn't updated is kept, after that the pr's comments and
+// commits are all fetched again the next time it changes
+const prFingerprintExpiry = 90 * 24 * time.Hour
+
+func prFingerprintKey(prID string) string {
+	return fmt.Sprintf("pr_fingerprint:%s", prID)
+}
+
+// prFingerprint returns the fingerprint from the last export, it's nil when everything should be fetched since the
+// pr wasn't exported before or updated is zero, such as for a historical export
+func (a *API) prFingerprint(pr PullRequestResponse, repoRefID string, updated time.Time) (*prFingerprint, error) {
+	if updated.IsZero() {
+		return nil, nil
+	}
+	prID := sdk.NewSourceCodePullRequestID(a.customerID, strconv.FormatInt(pr.ID, 10), a.refType, repoRefID)
+	key := prFingerprintKey(prID)
+	var f prFingerprint
+	ok, err := a.state.Get(key, &f)
+	if err != nil {
+		return nil, fmt.Errorf("error getting state for key %s: %w", key, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+	return &f, nil
+}
+
+// savePRFingerprint saves the fingerprint of an open pr so state doesn't keep one for every pr ever exported, a closed pr
+// rarely changes again so its fingerprint is deleted
+func (a *API) savePRFingerprint(pr PullRequestResponse, repoRefID string, lastComment commentMarker, commitShas []string) error {
+	prID := sdk.NewSourceCodePullRequestID(a.customerID, strconv.FormatInt(pr.ID, 10), a.refType, repoRefID)
+	key := prFingerprintKey(prID)
+	if pr.State != "OPEN" {
+		if a.state.Exists(key) {
+			return a.state.Delete(key)
+		}
+		return nil
+	}
+	return a.state.SetWithExpires(key, newPRFingerprint(pr, lastComment, commitShas), prFingerprintExpiry)
+}
@@ -5,29 +5,61 @@ import (
 	"fmt"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pinpt/agent/v4/sdk"
 )
 
-// FetchPullRequests gets team members
+// FetchPullRequests gets the prs updated after updated, or all of them when it's zero
 func (a *API) FetchPullRequests(reponame string, repoRefID string, updated time.Time) error {
-	sdk.LogDebug(a.logger, "fetching pull requests", "repo", reponame)
-	endpoint := sdk.JoinURL("repositories", reponame, "pullrequests")
+	_, err := a.FetchPullRequestsBetween(reponame, repoRefID, updated, time.Time{}, updated)
+	return err
+}
+
+// pullRequestsQuery returns the q param for prs updated after since and at or before until, zero times aren't a bound
+func pullRequestsQuery(since time.Time, until time.Time) string {
+	var conditions []string
+	if !since.IsZero() {
+		conditions = append(conditions, `updated_on > `+since.Format(updatedFormat))
+	}
+	if !until.IsZero() {
+		conditions = append(conditions, `updated_on <= `+until.Format(updatedFormat))
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+func pullRequestsParams(since time.Time, until time.Time) url.Values {
 	params := url.Values{}
 	params.Add("state", "MERGED")
 	params.Add("state", "SUPERSEDED")
 	params.Add("state", "OPEN")
-	if !updated.IsZero() {
-		params.Set("q", `updated_on > `+updated.Format(updatedFormat))
+	if q := pullRequestsQuery(since, until); q != "" {
+		params.Set("q", q)
 	}
 	params.Set("sort", "-updated_on")
+	return params
+}
+
+// FetchPullRequestsBetween gets the prs updated after since and at or before until, a zero time isn't a bound.
+// Comments and commits are fetched from updated, which is zero for prs which weren't exported before. It returns
+// the number of prs.
+func (a *API) FetchPullRequestsBetween(reponame string, repoRefID string, since time.Time, until time.Time, updated time.Time) (int, error) {
+	sdk.LogDebug(a.logger, "fetching pull requests", "repo", reponame, "since", since, "until", until)
+	endpoint := sdk.JoinURL("repositories", reponame, "pullrequests")
+	params := pullRequestsParams(since, until)
 
 	// Greater than 50 throws "Invalid pagelen"
 	params.Set("pagelen", "50")
+	params.Set("fields", pageFields(pullRequestFields))
 
 	var count int
-	if err := a.paginate(endpoint, params, func(obj json.RawMessage) error {
+	// each backfill window of a repo has its own total
+	window := repoRefID + " " + pullRequestsQuery(since, until)
+	size := func(total int64) {
+		a.progress.addPullRequests(window, total)
+	}
+	if err := a.paginateWithSize(endpoint, params, size, func(obj json.RawMessage) error {
 		rawResponse := []PullRequestResponse{}
 		if err := json.Unmarshal(obj, &rawResponse); err != nil {
 			return err
@@ -35,36 +67,78 @@ func (a *API) FetchPullRequests(reponame string, repoRefID string, updated time.
 		if err := a.processPullRequests(rawResponse, reponame, repoRefID, updated); err != nil {
 			return err
 		}
+		a.progress.pullRequestsDone(window, len(rawResponse))
 		count += len(rawResponse)
 		return nil
 	}); err != nil {
-		return fmt.Errorf("error fetching prs. err %v", err)
+		return count, fmt.Errorf("error fetching prs. err %w", err)
 	}
 	sdk.LogDebug(a.logger, "finished fetching pull requests", "repo", reponame, "count", count)
-	return nil
+	return count, nil
+}
+
+// HasPullRequestsBefore returns true if the repo has prs last updated at or before until
+func (a *API) HasPullRequestsBefore(reponame string, until time.Time) (bool, error) {
+	endpoint := sdk.JoinURL("repositories", reponame, "pullrequests")
+	params := pullRequestsParams(time.Time{}, until)
+	params.Set("pagelen", "1")
+	params.Set("fields", "values.id")
+	var res struct {
+		Values []json.RawMessage `json:"values"`
+	}
+	if _, err := a.get(endpoint, params, &res); err != nil {
+		return false, fmt.Errorf("error checking for older prs: %w", err)
+	}
+	return len(res.Values) > 0, nil
 }
 
 func (a *API) processPullRequests(raw []PullRequestResponse, reponame string, repoRefID string, updated time.Time) error {
-	async := sdk.NewAsync(10)
-	for _, _pr := range raw {
+	async := sdk.NewAsync(a.concurrency())
+	lastComments := make([]commentMarker, len(raw))
+	commitShas := make([][]string, len(raw))
+	for i, _pr := range raw {
 		pr := _pr
+		i := i
+		fingerprint, err := a.prFingerprint(pr, repoRefID, updated)
+		if err != nil {
+			async.Wait()
+			return err
+		}
 		async.Do(func() error {
-			return a.fetchPullRequestComments(pr, reponame, repoRefID, updated)
+			var err error
+			lastComments[i], err = a.syncPullRequestComments(pr, fingerprint, reponame, repoRefID, updated)
+			return err
 		})
 		async.Do(func() error {
+			if err := a.SendPullRequestUsers(pr); err != nil {
+				return err
+			}
 			return a.ExtractPullRequestReview(pr, repoRefID)
 		})
 		async.Do(func() error {
-			shas, err := a.fetchPullRequestCommits(pr, reponame, repoRefID, updated)
-			if err != nil {
-				return err
+			shas := fingerprint.commitShas()
+			if fingerprint.commitsChanged(pr) {
+				var err error
+				if shas, err = a.fetchPullRequestCommits(pr, reponame, repoRefID, updated); err != nil {
+					return err
+				}
+				// only the commits since the last export were fetched
+				shas = fingerprint.mergeCommitShas(shas)
+			} else {
+				sdk.LogDebug(a.logger, "skipping unchanged pr commits", "repo", reponame, "pr", pr.ID)
 			}
+			commitShas[i] = shas
 			return a.sendPullRequest(pr, repoRefID, updated, shas)
 		})
 	}
 	if err := async.Wait(); err != nil {
 		return err
 	}
+	for i, pr := range raw {
+		if err := a.savePRFingerprint(pr, repoRefID, lastComments[i], commitShas[i]); err != nil {
+			return fmt.Errorf("error saving pr fingerprint: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -103,28 +177,88 @@ func (a *API) syncPRReviewRequests(prID string, currentRequests map[string]bool)
 	return nil
 }
 
+func prChangesRequestKey(prID string) string {
+	return fmt.Sprintf("changes_requests:%s", prID)
+}
+
+// syncPRChangesRequests saves the reviewers currently requesting changes with when they did, and deactivates the
+// changes requested review of each one which was removed since
+func (a *API) syncPRChangesRequests(raw PullRequestResponse, repoRefID string, currentRequests map[string]time.Time) error {
+	prID := sdk.NewSourceCodePullRequestID(a.customerID, strconv.FormatInt(raw.ID, 10), a.refType, repoRefID)
+	key := prChangesRequestKey(prID)
+	var prevRequests map[string]time.Time
+	if _, err := a.state.Get(key, &prevRequests); err != nil {
+		return fmt.Errorf("error getting state for key %s: %w", key, err)
+	}
+	for userRefID, date := range prevRequests {
+		if _, ok := currentRequests[userRefID]; !ok {
+			review := a.newPullRequestReview(raw, userRefID, sdk.SourceCodePullRequestReviewStateChangesRequested, date, repoRefID)
+			review.Active = false
+			if err := a.pipe.Write(review); err != nil {
+				return fmt.Errorf("error writing review to pipe: %w", err)
+			}
+		}
+	}
+	if len(currentRequests) > 0 || len(prevRequests) > 0 {
+		return a.state.Set(key, currentRequests)
+	}
+	return nil
+}
+
+// TrackChangesRequest saves a changes request from a webhook as if it was seen on the pr, so ExtractPullRequestReview
+// deactivates it once it's removed even when it was made before changes requests were saved
+func (a *API) TrackChangesRequest(raw PullRequestResponse, changes ChangesRequestResponse, repoRefID string) error {
+	prID := sdk.NewSourceCodePullRequestID(a.customerID, strconv.FormatInt(raw.ID, 10), a.refType, repoRefID)
+	key := prChangesRequestKey(prID)
+	requests := make(map[string]time.Time)
+	if _, err := a.state.Get(key, &requests); err != nil {
+		return fmt.Errorf("error getting state for key %s: %w", key, err)
+	}
+	if _, ok := requests[changes.User.RefID()]; ok {
+		return nil
+	}
+	requests[changes.User.RefID()] = changes.Date
+	return a.state.Set(key, requests)
+}
+
+func (a *API) newPullRequestReview(raw PullRequestResponse, userRefID string, state sdk.SourceCodePullRequestReviewState, date time.Time, repoRefID string) *sdk.SourceCodePullRequestReview {
+	refID := sdk.Hash(raw.ID, userRefID)
+	if state != sdk.SourceCodePullRequestReviewStateApproved {
+		// approvals keep the original ref_id so existing reviews are updated in place
+		refID = sdk.Hash(raw.ID, userRefID, state)
+	}
+	return &sdk.SourceCodePullRequestReview{
+		Active:                true,
+		CreatedDate:           sdk.SourceCodePullRequestReviewCreatedDate(*sdk.NewDateWithTime(date)),
+		IntegrationInstanceID: sdk.StringPointer(a.integrationInstanceID),
+		CustomerID:            a.customerID,
+		PullRequestID:         sdk.NewSourceCodePullRequestID(a.customerID, strconv.FormatInt(raw.ID, 10), a.refType, repoRefID),
+		RefID:                 refID,
+		RefType:               a.refType,
+		RepoID:                sdk.NewSourceCodeRepoID(a.customerID, repoRefID, a.refType),
+		UserRefID:             userRefID,
+		State:                 state,
+	}
+}
+
 // ExtractPullRequestReview will pull out reviews and review requests from a pr and send them to the pipe
 func (a *API) ExtractPullRequestReview(raw PullRequestResponse, repoRefID string) error {
 	prID := sdk.NewSourceCodePullRequestID(a.customerID, strconv.FormatInt(raw.ID, 10), a.refType, repoRefID)
-	repoID := sdk.NewSourceCodeRepoID(a.customerID, repoRefID, a.refType)
 	requests := make(map[string]bool)
+	changesRequests := make(map[string]time.Time)
 	for _, participant := range raw.Participants {
 		if participant.Role == "REVIEWER" {
 			if participant.Approved {
-				if err := a.pipe.Write(&sdk.SourceCodePullRequestReview{
-					Active:                true,
-					CreatedDate:           sdk.SourceCodePullRequestReviewCreatedDate(*sdk.NewDateWithTime(participant.ParticipatedOn)),
-					IntegrationInstanceID: sdk.StringPointer(a.integrationInstanceID),
-					CustomerID:            a.customerID,
-					PullRequestID:         prID,
-					RefID:                 sdk.Hash(raw.ID, participant.User.AccountID),
-					RefType:               a.refType,
-					RepoID:                repoID,
-					UserRefID:             participant.User.AccountID,
-					State:                 sdk.SourceCodePullRequestReviewStateApproved,
-				}); err != nil {
+				review := a.newPullRequestReview(raw, participant.User.AccountID, sdk.SourceCodePullRequestReviewStateApproved, participant.ParticipatedOn, repoRefID)
+				if err := a.pipe.Write(review); err != nil {
 					return fmt.Errorf("error writing review to pipe: %w", err)
 				}
+			} else if participant.State == "changes_requested" {
+				review := a.newPullRequestReview(raw, participant.User.AccountID, sdk.SourceCodePullRequestReviewStateChangesRequested, participant.ParticipatedOn, repoRefID)
+				if err := a.pipe.Write(review); err != nil {
+					return fmt.Errorf("error writing review to pipe: %w", err)
+				}
+				changesRequests[participant.User.AccountID] = participant.ParticipatedOn
 			} else if participant.ParticipatedOn.IsZero() {
 				// a non-participated reviewer is counted as a request
 				id := sdk.NewSourceCodePullRequestReviewRequestID(a.customerID, a.refType, prID, participant.User.AccountID)
@@ -145,6 +279,9 @@ func (a *API) ExtractPullRequestReview(raw PullRequestResponse, repoRefID string
 			}
 		}
 	}
+	if err := a.syncPRChangesRequests(raw, repoRefID, changesRequests); err != nil {
+		return err
+	}
 	return a.syncPRReviewRequests(prID, requests)
 }
 
@@ -172,12 +309,13 @@ func (a *API) ConvertPullRequest(raw PullRequestResponse, repoRefID string, comm
 		BranchID:              sdk.NewSourceCodeBranchID(a.customerID, repoID, a.refType, raw.Source.Branch.Name, firstCommitID),
 		BranchName:            raw.Source.Branch.Name,
 		Title:                 raw.Title,
-		Description:           `<div class="source-bitbucket">` + sdk.ConvertMarkdownToHTML(raw.Description) + "</div>",
+		Description:           a.renderBitbucketContent(raw.Summary.HTML, raw.Description, raw.Destination.Repository.FullName),
 		URL:                   raw.Links.HTML.Href,
 		Identifier:            fmt.Sprintf("#%d", raw.ID), // in bitbucket looks like #1 is the format for PR identifiers in their UI
 		CreatedByRefID:        raw.Author.RefID(),
 		CommitShas:            commitShas,
 		CommitIds:             commitIDs,
+		IssueKeys:             a.issues.Extract(raw.Title, raw.Source.Branch.Name, raw.Description),
 	}
 	sdk.ConvertTimeToDateModel(raw.CreatedOn, &pr.CreatedDate)
 	sdk.ConvertTimeToDateModel(raw.UpdatedOn, &pr.UpdatedDate)
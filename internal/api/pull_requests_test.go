@@ -0,0 +1,123 @@
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pinpt/agent/v4/sdk"
+	"github.com/pinpt/bitbucket/internal/local"
+)
+
+func TestConvertPullRequest(t *testing.T) {
+	a := newTestAPI()
+	tests := []struct {
+		state    string
+		status   sdk.SourceCodePullRequestStatus
+		closedBy string
+		mergedBy string
+	}{
+		{"OPEN", sdk.SourceCodePullRequestStatusOpen, "", ""},
+		{"DECLINED", sdk.SourceCodePullRequestStatusClosed, "bob-id", ""},
+		{"MERGED", sdk.SourceCodePullRequestStatusMerged, "", "bob-id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			var raw PullRequestResponse
+			raw.ID = 7
+			raw.State = tt.state
+			raw.Title = "PLAT-1 fix login"
+			raw.Source.Branch.Name = "feature/login"
+			raw.Author.AccountID = "alice-id"
+			raw.ClosedBy.AccountID = "bob-id"
+			raw.MergeCommit.Hash = "9e9e"
+			raw.Links.HTML.Href = "https://bitbucket.org/acme/api/pull-requests/7"
+			raw.UpdatedOn = time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+			pr := a.ConvertPullRequest(raw, "{repo}", []string{"a1", "b2"})
+			if pr.Status != tt.status {
+				t.Errorf("expected status %v, got %v", tt.status, pr.Status)
+			}
+			if pr.ClosedByRefID != tt.closedBy || pr.MergedByRefID != tt.mergedBy {
+				t.Errorf("expected closed by %q and merged by %q, got %q and %q", tt.closedBy, tt.mergedBy, pr.ClosedByRefID, pr.MergedByRefID)
+			}
+			if pr.RefID != "7" || pr.Identifier != "#7" || pr.CreatedByRefID != "alice-id" || pr.BranchName != "feature/login" {
+				t.Errorf("unexpected pr %+v", pr)
+			}
+			if !reflect.DeepEqual(pr.CommitShas, []string{"a1", "b2"}) || len(pr.CommitIds) != 2 {
+				t.Errorf("unexpected commits %v %v", pr.CommitShas, pr.CommitIds)
+			}
+			if !reflect.DeepEqual(pr.IssueKeys, []string{"PLAT-1"}) {
+				t.Errorf("unexpected issue keys %v", pr.IssueKeys)
+			}
+		})
+	}
+}
+
+func TestConvertPullRequestComment(t *testing.T) {
+	a := newTestAPI()
+	var raw PullRequestCommentResponse
+	raw.ID = 10
+	raw.User.AccountID = "bob-id"
+	raw.Content.Raw = "looks good"
+	raw.Content.HTML = "<p>looks good</p>"
+	raw.Links.HTML.Href = "https://bitbucket.org/acme/api/pull-requests/7/_/diff#comment-10"
+	comment := a.ConvertPullRequestComment(raw, "acme/api", "{repo}", "7")
+	if comment.RefID != "10" || comment.UserRefID != "bob-id" || !comment.Active {
+		t.Errorf("unexpected comment %+v", comment)
+	}
+	if comment.PullRequestID != sdk.NewSourceCodePullRequestID("1234", "7", "bitbucket", "{repo}") {
+		t.Errorf("expected the comment to be on pr 7, got %s", comment.PullRequestID)
+	}
+	if comment.URL != raw.Links.HTML.Href {
+		t.Errorf("unexpected url %s", comment.URL)
+	}
+}
+
+func TestExtractPullRequestReviewChangesRequests(t *testing.T) {
+	a := newTestAPI()
+	pipe := a.pipe.(*local.MemoryPipe)
+	pr := func(state string) PullRequestResponse {
+		var raw PullRequestResponse
+		body := `{"id": 7, "participants": [{"role": "REVIEWER", "state": "` + state + `", "participated_on": "2020-06-01T10:00:00+00:00", "user": {"account_id": "bob-id"}}]}`
+		if err := json.Unmarshal([]byte(body), &raw); err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	reviews := func() []*sdk.SourceCodePullRequestReview {
+		var found []*sdk.SourceCodePullRequestReview
+		for _, object := range pipe.Objects() {
+			if review, ok := object.(*sdk.SourceCodePullRequestReview); ok {
+				found = append(found, review)
+			}
+		}
+		pipe.Reset()
+		return found
+	}
+
+	if err := a.ExtractPullRequestReview(pr("changes_requested"), "{repo}"); err != nil {
+		t.Fatal(err)
+	}
+	requested := reviews()
+	if len(requested) != 1 || !requested[0].Active || requested[0].State != sdk.SourceCodePullRequestReviewStateChangesRequested {
+		t.Fatalf("expected an active changes requested review, got %v", requested)
+	}
+
+	// bob removed the changes request since the last export
+	if err := a.ExtractPullRequestReview(pr(""), "{repo}"); err != nil {
+		t.Fatal(err)
+	}
+	removed := reviews()
+	if len(removed) != 1 || removed[0].Active || removed[0].RefID != requested[0].RefID || removed[0].CreatedDate != requested[0].CreatedDate {
+		t.Errorf("expected the changes requested review to be deactivated, got %v", removed)
+	}
+
+	// it's only deactivated once
+	if err := a.ExtractPullRequestReview(pr(""), "{repo}"); err != nil {
+		t.Fatal(err)
+	}
+	if again := reviews(); len(again) != 0 {
+		t.Errorf("expected no more reviews, got %v", again)
+	}
+}
@@ -18,8 +18,9 @@ func (a *API) FetchRepos(team string, updated time.Time, repo chan<- *sdk.Source
 		params.Set("q", `updated_on > `+updated.Format(updatedFormat))
 	}
 	params.Set("sort", "-updated_on")
+	params.Set("fields", pageFields(repoFields))
 	var count int
-	if err := a.paginate(endpoint, params, func(obj json.RawMessage) error {
+	if err := a.paginateWithSize(endpoint, params, a.progress.addListedRepos, func(obj json.RawMessage) error {
 		rawRepos := []RepoResponse{}
 		if err := json.Unmarshal(obj, &rawRepos); err != nil {
 			return err
@@ -32,12 +33,19 @@ func (a *API) FetchRepos(team string, updated time.Time, repo chan<- *sdk.Source
 		}
 		return nil
 	}); err != nil {
-		return fmt.Errorf("error fetching repos. err %v", err)
+		return fmt.Errorf("error fetching repos. err %w", err)
 	}
 	sdk.LogDebug(a.logger, "finished fetching repos", "team", team, "count", count)
 	return nil
 }
 
+// FetchRepo returns a single repo by full name
+func (a *API) FetchRepo(fullName string) (RepoResponse, error) {
+	var out RepoResponse
+	_, err := a.get(sdk.JoinURL("repositories", fullName), nil, &out)
+	return out, err
+}
+
 // FetchRepoCount will return the number of repos for a workspace
 func (a *API) FetchRepoCount(workspaceSlug string) (int64, error) {
 	endpoint := sdk.JoinURL("repositories", workspaceSlug)
@@ -48,9 +56,9 @@ func (a *API) FetchRepoCount(workspaceSlug string) (int64, error) {
 func (a *API) ConvertRepo(raw RepoResponse) *sdk.SourceCodeRepo {
 	var visibility sdk.SourceCodeRepoVisibility
 	if raw.IsPrivate {
-		visibility = sdk.SourceCodeRepoVisibilityPublic
-	} else {
 		visibility = sdk.SourceCodeRepoVisibilityPrivate
+	} else {
+		visibility = sdk.SourceCodeRepoVisibilityPublic
 	}
 	// .Affiliation is set in the main bitbucket.go file
 	return &sdk.SourceCodeRepo{
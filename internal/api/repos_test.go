@@ -0,0 +1,45 @@
+package api
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/pinpt/agent/v4/sdk"
+	"github.com/pinpt/bitbucket/internal/local"
+)
+
+func newTestAPI() *API {
+	return New(local.NewLogger(ioutil.Discard), nil, local.NewMemoryState(), local.NewMemoryPipe(), "1234", "5678", "bitbucket", nil)
+}
+
+func TestConvertRepo(t *testing.T) {
+	a := newTestAPI()
+	tests := []struct {
+		name       string
+		private    bool
+		visibility sdk.SourceCodeRepoVisibility
+	}{
+		{"private", true, sdk.SourceCodeRepoVisibilityPrivate},
+		{"public", false, sdk.SourceCodeRepoVisibilityPublic},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var raw RepoResponse
+			raw.UUID = "{repo}"
+			raw.FullName = "acme/api"
+			raw.IsPrivate = tt.private
+			raw.Mainbranch.Name = "main"
+			raw.Links.HTML.Href = "https://bitbucket.org/acme/api"
+			repo := a.ConvertRepo(raw)
+			if repo.Visibility != tt.visibility {
+				t.Errorf("expected visibility %v, got %v", tt.visibility, repo.Visibility)
+			}
+			if repo.RefID != "{repo}" || repo.Name != "acme/api" || repo.DefaultBranch != "main" || repo.URL != raw.Links.HTML.Href {
+				t.Errorf("unexpected repo %+v", repo)
+			}
+			if repo.IntegrationInstanceID == nil || *repo.IntegrationInstanceID != "5678" {
+				t.Errorf("expected the integration instance id to be set")
+			}
+		})
+	}
+}
@@ -2,9 +2,10 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/pinpt/agent/v4/sdk"
@@ -17,12 +18,30 @@ func (a *API) FetchMyUser() (MyUser, error) {
 	return out, err
 }
 
+// FetchScopes returns the oauth scopes granted to the credentials by requesting endpoint, ok is false if bitbucket didn't return any
+func (a *API) FetchScopes(endpoint string) (scopes []string, ok bool, err error) {
+	var out interface{}
+	res, err := a.get(endpoint, nil, &out)
+	if err != nil {
+		return nil, false, err
+	}
+	header := res.Headers.Get("X-OAuth-Scopes")
+	if header == "" {
+		return nil, false, nil
+	}
+	for _, scope := range strings.Split(header, ",") {
+		scopes = append(scopes, strings.TrimSpace(scope))
+	}
+	return scopes, true, nil
+}
+
 // FetchUsers gets team members
 func (a *API) FetchUsers(team string, updated time.Time) error {
 	sdk.LogDebug(a.logger, "fetching users", "team", team)
 	endpoint := sdk.JoinURL("workspaces", team, "members")
 	params := url.Values{}
 	var count int
+	members := make(map[string]bool)
 	if err := a.paginate(endpoint, params, func(obj json.RawMessage) error {
 		rawUsers := []userResponse{}
 		if err := json.Unmarshal(obj, &rawUsers); err != nil {
@@ -31,25 +50,216 @@ func (a *API) FetchUsers(team string, updated time.Time) error {
 		if err := a.sendUsers(rawUsers, updated); err != nil {
 			return err
 		}
+		for _, meta := range rawUsers {
+			if isActiveAccount(meta.User) {
+				members[meta.User.RefID()] = true
+			}
+		}
 		count += len(rawUsers)
 		return nil
 	}); err != nil {
-		rerr := err.(*sdk.HTTPError)
-		if rerr.StatusCode != http.StatusForbidden {
-			return fmt.Errorf("error fetching users. err %v", err)
+		if !errors.Is(err, ErrForbidden) {
+			return fmt.Errorf("error fetching users. err %w", err)
 		}
+		// without the full list of members we can't tell who left
+		return nil
+	}
+	if err := a.saveMembers(team, members); err != nil {
+		return err
 	}
 	sdk.LogDebug(a.logger, "finished fetching users", "team", team, "count", count)
 	return nil
 }
 
+func membersKey(team string) string {
+	return fmt.Sprintf("members:%s", team)
+}
+
+// allMembersKey is the state key for the members of every team in the last export
+const allMembersKey = "members"
+
+// isActiveAccount returns false for deactivated or closed accounts, which still show up as members
+func isActiveAccount(user attlassianUser) bool {
+	return user.AccountStatus == "" || user.AccountStatus == "active"
+}
+
+// saveMembers remembers the current members of a team, for isMember and SyncDepartedMembers
+func (a *API) saveMembers(team string, members map[string]bool) error {
+	a.members.Store(team, members)
+	ids := make([]string, 0, len(members))
+	for id := range members {
+		ids = append(ids, id)
+	}
+	return a.state.Set(membersKey(team), ids)
+}
+
+// teamMembers returns the members of a team from this export, or from the last one the team's members were fetched in
+func (a *API) teamMembers(team string) map[string]bool {
+	members, ok := a.members.Load(team)
+	if !ok {
+		var ids []string
+		if _, err := a.state.Get(membersKey(team), &ids); err != nil {
+			sdk.LogWarn(a.logger, "error getting team members from state", "team", team, "err", err)
+		}
+		m := make(map[string]bool)
+		for _, id := range ids {
+			m[id] = true
+		}
+		members, _ = a.members.LoadOrStore(team, m)
+	}
+	return members.(map[string]bool)
+}
+
+// SyncDepartedMembers sends an update for users which were a member of any of the teams in the last export but aren't
+// a member of any of them anymore. It's called once the members of every team were fetched, a team whose members
+// couldn't be fetched keeps its members from the last export.
+func (a *API) SyncDepartedMembers(teams []string) error {
+	current := make(map[string]bool)
+	for _, team := range teams {
+		for refID := range a.teamMembers(team) {
+			current[refID] = true
+		}
+	}
+	var prevMembers []string
+	ok, err := a.state.Get(allMembersKey, &prevMembers)
+	if err != nil {
+		return fmt.Errorf("error getting state for key %s: %w", allMembersKey, err)
+	}
+	if ok {
+		for _, refID := range prevMembers {
+			if !current[refID] {
+				sdk.LogDebug(a.logger, "user is no longer a member", "ref_id", refID)
+				update := sdk.SourceCodeUserUpdate{}
+				v := false
+				update.Set.Member = &v
+				if err := a.pipe.Write(sdk.NewSourceCodeUserUpdate(a.customerID, a.integrationInstanceID, refID, a.refType, update)); err != nil {
+					return fmt.Errorf("error writing user update to pipe: %w", err)
+				}
+			}
+		}
+	}
+	a.allMembersMu.Lock()
+	a.allMembers = current
+	a.allMembersMu.Unlock()
+	ids := make([]string, 0, len(current))
+	for id := range current {
+		ids = append(ids, id)
+	}
+	return a.state.Set(allMembersKey, ids)
+}
+
+// isMember returns true if the user is a member of any team in the export, or was in the last export when members
+// haven't been synced yet, such as for webhooks
+func (a *API) isMember(refID string) bool {
+	a.allMembersMu.Lock()
+	defer a.allMembersMu.Unlock()
+	if a.allMembers == nil {
+		var ids []string
+		if _, err := a.state.Get(allMembersKey, &ids); err != nil {
+			sdk.LogWarn(a.logger, "error getting members from state", "err", err)
+		}
+		a.allMembers = make(map[string]bool)
+		for _, id := range ids {
+			a.allMembers[id] = true
+		}
+	}
+	return a.allMembers[refID]
+}
+
+// sendParticipantUser sends the user record for a user seen on a pr, such as an author, reviewer, commenter or closer,
+// when they aren't a member of any team. Members are sent by FetchUsers and each user is only sent once.
+func (a *API) sendParticipantUser(user attlassianUser) error {
+	refID := user.RefID()
+	if refID == "" {
+		return nil
+	}
+	a.users.Store(user.AccountID, user)
+	if a.isMember(refID) {
+		return nil
+	}
+	if _, sent := a.participants.LoadOrStore(refID, true); sent {
+		return nil
+	}
+	usertype := sdk.SourceCodeUserTypeHuman
+	if a.bots.IsBot(user) {
+		usertype = sdk.SourceCodeUserTypeBot
+	}
+	if err := a.pipe.Write(&sdk.SourceCodeUser{
+		AvatarURL:             sdk.StringPointer(user.Links.Avatar.Href),
+		CustomerID:            a.customerID,
+		RefID:                 refID,
+		RefType:               a.refType,
+		Member:                false,
+		Name:                  user.DisplayName,
+		Type:                  usertype,
+		URL:                   sdk.StringPointer(user.Links.HTML.Href),
+		IntegrationInstanceID: sdk.StringPointer(a.integrationInstanceID),
+	}); err != nil {
+		return fmt.Errorf("error sending user to pipe: %w", err)
+	}
+	return nil
+}
+
+// SendPullRequestUsers sends the user records for the author, closer, participants and users mentioned in the
+// description of a pr which aren't members
+func (a *API) SendPullRequestUsers(raw PullRequestResponse) error {
+	users := []attlassianUser{raw.Author, raw.ClosedBy}
+	for _, participant := range raw.Participants {
+		users = append(users, participant.User)
+	}
+	for _, user := range users {
+		if err := a.sendParticipantUser(user); err != nil {
+			return err
+		}
+	}
+	return a.sendMentionedUsers(raw.Description)
+}
+
+// sendMentionedUsers sends the user records for the users @mentioned in raw markdown which aren't members, so every
+// mention in a description or comment is a user that was sent. A user who hasn't been seen yet is fetched.
+func (a *API) sendMentionedUsers(raw string) error {
+	for _, accountID := range ExtractMentions(raw) {
+		if a.isMember(accountID) {
+			continue
+		}
+		if _, sent := a.participants.Load(accountID); sent {
+			continue
+		}
+		user, err := a.fetchUser(accountID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) || errors.Is(err, ErrForbidden) {
+				sdk.LogDebug(a.logger, "skipping mentioned user which can't be fetched", "account_id", accountID, "err", err)
+				continue
+			}
+			return fmt.Errorf("error fetching mentioned user: %w", err)
+		}
+		if err := a.sendParticipantUser(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchUser returns a user by account_id, from the users already seen when it's one of them
+func (a *API) fetchUser(accountID string) (attlassianUser, error) {
+	if user, ok := a.users.Load(accountID); ok {
+		return user.(attlassianUser), nil
+	}
+	params := url.Values{}
+	params.Set("fields", strings.Join(userFields, ","))
+	var user attlassianUser
+	if _, err := a.get(sdk.JoinURL("users", accountID), params, &user); err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
 func (a *API) sendUsers(raw []userResponse, updated time.Time) error {
 	for _, meta := range raw {
 		user := meta.User
-		var usertype sdk.SourceCodeUserType
-		if user.Type == "user" {
-			usertype = sdk.SourceCodeUserTypeHuman
-		} else {
+		a.users.Store(user.AccountID, user)
+		usertype := sdk.SourceCodeUserTypeHuman
+		if a.bots.IsBot(user) {
 			usertype = sdk.SourceCodeUserTypeBot
 		}
 		if err := a.pipe.Write(&sdk.SourceCodeUser{
@@ -57,7 +267,7 @@ func (a *API) sendUsers(raw []userResponse, updated time.Time) error {
 			CustomerID:            a.customerID,
 			RefID:                 user.RefID(),
 			RefType:               a.refType,
-			Member:                true,
+			Member:                isActiveAccount(user),
 			Name:                  user.DisplayName,
 			Type:                  usertype,
 			URL:                   sdk.StringPointer(user.Links.HTML.Href),
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+
+	"github.com/pinpt/agent/v4/sdk"
+	"github.com/pinpt/bitbucket/internal/local"
+)
+
+func TestSyncDepartedMembers(t *testing.T) {
+	a := newTestAPI()
+	pipe := a.pipe.(*local.MemoryPipe)
+	members := func(ids ...string) map[string]bool {
+		m := make(map[string]bool)
+		for _, id := range ids {
+			m[id] = true
+		}
+		return m
+	}
+	saved := func() []string {
+		var ids []string
+		if _, err := a.state.Get(allMembersKey, &ids); err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(ids)
+		return ids
+	}
+
+	// the first export has nobody to depart
+	a.saveMembers("acme", members("alice", "bob"))
+	a.saveMembers("globex", members("bob", "carol"))
+	if err := a.SyncDepartedMembers([]string{"acme", "globex"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(pipe.Objects()) != 0 {
+		t.Errorf("expected no updates on the first export, got %d", len(pipe.Objects()))
+	}
+
+	// bob left acme but is still in globex and carol left globex, which was her only team
+	a.saveMembers("acme", members("alice"))
+	a.saveMembers("globex", members("bob"))
+	if err := a.SyncDepartedMembers([]string{"acme", "globex"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(pipe.Objects()) != 1 {
+		t.Errorf("expected only carol to depart, got %d updates", len(pipe.Objects()))
+	}
+	if ids := saved(); len(ids) != 2 || ids[0] != "alice" || ids[1] != "bob" {
+		t.Errorf("expected alice and bob to be members, got %v", ids)
+	}
+
+	// globex couldn't be fetched in the next export, so its members from the last one are kept
+	pipe.Reset()
+	b := New(a.logger, nil, a.state, pipe, "1234", "5678", "bitbucket", nil)
+	b.saveMembers("acme", members("alice"))
+	if err := b.SyncDepartedMembers([]string{"acme", "globex"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(pipe.Objects()) != 0 {
+		t.Errorf("expected nobody to depart from a team that wasn't fetched, got %d updates", len(pipe.Objects()))
+	}
+}
+
+func TestSendMentionedUsers(t *testing.T) {
+	var requests []string
+	a, _ := newServerTestAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.URL.Path != "/2.0/users/carol-id" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"type": "user", "account_id": "carol-id", "display_name": "Carol White"}`)
+	})
+	pipe := a.pipe.(*local.MemoryPipe)
+	a.saveMembers("acme", map[string]bool{"alice-id": true})
+	if err := a.SyncDepartedMembers([]string{"acme"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// alice is a member, carol is fetched and gone-id can't be found
+	var comment PullRequestCommentResponse
+	comment.Content.Raw = "thanks @{alice-id} and @{carol-id}, cc @{gone-id} @{carol-id}"
+	if err := a.SendCommentUsers(comment); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 || requests[0] != "/2.0/users/carol-id" || requests[1] != "/2.0/users/gone-id" {
+		t.Errorf("expected carol and gone-id to be fetched once, got %v", requests)
+	}
+	var users []*sdk.SourceCodeUser
+	for _, object := range pipe.Objects() {
+		if user, ok := object.(*sdk.SourceCodeUser); ok {
+			users = append(users, user)
+		}
+	}
+	if len(users) != 1 || users[0].RefID != "carol-id" || users[0].Name != "Carol White" || users[0].Member {
+		t.Errorf("expected carol to be sent as a non member, got %v", users)
+	}
+}
@@ -15,7 +15,7 @@ type WebHookEventName string
 // CreateWebHook creates a webhook, deleting existing ones if exist
 func (a *API) CreateWebHook(reponame, repoid, userid, ur string, hooks []WebHookEventName) error {
 	endpoint := sdk.JoinURL("repositories", reponame, "hooks")
-	async := sdk.NewAsync(10)
+	async := sdk.NewAsync(a.concurrency())
 	for _, _h := range hooks {
 		h := string(_h)
 		u := ur
@@ -25,12 +25,15 @@ func (a *API) CreateWebHook(reponame, repoid, userid, ur string, hooks []WebHook
 			u += "&" + params.Encode()
 			payload := webhookPayload{
 				Active:      true,
-				CreatorID:   "user:" + userid,
 				Description: webhookName,
 				Events:      []string{h},
 				SubjectKey:  "repository:" + repoid,
 				URL:         u,
 			}
+			if userid != "" {
+				// access tokens don't have a user to own the webhook
+				payload.CreatorID = "user:" + userid
+			}
 			var out struct {
 				UUID string `json:"uuid"`
 			}
@@ -8,13 +8,64 @@ import (
 	"github.com/pinpt/agent/v4/sdk"
 )
 
-// FetchWorkSpaces returns all workspaces
+// FetchWorkSpaces returns all workspaces the user is a member of
 func (a *API) FetchWorkSpaces() ([]WorkSpacesResponse, error) {
-	sdk.LogDebug(a.logger, "fetching workspaces")
+	return a.fetchWorkSpaces("member")
+}
+
+// FetchFollowedWorkSpaces returns the public workspaces the user collaborates on without being a member,
+// bitbucket has no api for following so these are the closest to what the user follows
+func (a *API) FetchFollowedWorkSpaces() ([]WorkSpacesResponse, error) {
+	member, err := a.FetchWorkSpaces()
+	if err != nil {
+		return nil, err
+	}
+	collaborator, err := a.fetchWorkSpaces("collaborator")
+	if err != nil {
+		return nil, err
+	}
+	members := make(map[string]bool)
+	for _, ws := range member {
+		members[ws.UUID] = true
+	}
+	var followed []WorkSpacesResponse
+	for _, ws := range collaborator {
+		if !ws.IsPrivate && !members[ws.UUID] {
+			followed = append(followed, ws)
+		}
+	}
+	return followed, nil
+}
+
+// FetchWorkSpacePermissions returns the user's permission (owner, member or collaborator) for their workspaces keyed by workspace uuid
+func (a *API) FetchWorkSpacePermissions() (map[string]string, error) {
+	sdk.LogDebug(a.logger, "fetching workspace permissions")
+	endpoint := sdk.JoinURL("user", "permissions", "workspaces")
+	params := url.Values{}
+	params.Set("pagelen", "100")
+	permissions := make(map[string]string)
+	err := a.paginate(endpoint, params, func(obj json.RawMessage) error {
+		res := []workspacePermissionResponse{}
+		if err := json.Unmarshal(obj, &res); err != nil {
+			return err
+		}
+		for _, perm := range res {
+			permissions[perm.Workspace.UUID] = perm.Permission
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching workspace permissions. err %w", err)
+	}
+	return permissions, nil
+}
+
+func (a *API) fetchWorkSpaces(role string) ([]WorkSpacesResponse, error) {
+	sdk.LogDebug(a.logger, "fetching workspaces", "role", role)
 	endpoint := "workspaces"
 	params := url.Values{}
 	params.Set("pagelen", "100")
-	params.Set("role", "member")
+	params.Set("role", role)
 
 	var workspaces []WorkSpacesResponse
 	err := a.paginate(endpoint, params, func(obj json.RawMessage) error {
@@ -27,12 +78,33 @@ func (a *API) FetchWorkSpaces() ([]WorkSpacesResponse, error) {
 		return nil
 	})
 	if err != nil {
-		return nil, fmt.Errorf("error fetching workspaces. err %v", err)
+		return nil, fmt.Errorf("error fetching workspaces. err %w", err)
 	}
 	sdk.LogDebug(a.logger, "finished fetching workspaces")
 	return workspaces, nil
 }
 
+// FetchWorkSpace returns a single workspace by slug
+func (a *API) FetchWorkSpace(slug string) (WorkSpacesResponse, error) {
+	var out WorkSpacesResponse
+	_, err := a.get(sdk.JoinURL("workspaces", slug), nil, &out)
+	return out, err
+}
+
+// ExtractRepoWorkSpace returns the workspace of a repo
+func ExtractRepoWorkSpace(repo RepoResponse) WorkSpacesResponse {
+	var ws WorkSpacesResponse
+	ws.Name = repo.Workspace.Name
+	ws.Slug = repo.Workspace.Slug
+	ws.Type = repo.Workspace.Type
+	ws.UUID = repo.Workspace.UUID
+	ws.IsPrivate = repo.IsPrivate
+	ws.Links.Avatar.Href = repo.Workspace.Links.Avatar.Href
+	ws.Links.HTML.Href = repo.Workspace.Links.HTML.Href
+	ws.Links.Self.Href = repo.Workspace.Links.Self.Href
+	return ws
+}
+
 // ExtractWorkSpaceIDs will return just the slugs of the give workspaces
 func ExtractWorkSpaceIDs(ws []WorkSpacesResponse) []string {
 	var ids []string
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pinpt/agent/v4/sdk"
+	"github.com/pinpt/bitbucket/internal/api"
+)
+
+type authMode string
+
+const (
+	// authModeAppPassword is a username and app password sent with basic auth
+	authModeAppPassword authMode = "app_password"
+	// authModeOAuth2 is an oauth2 access token which is refreshed by the agent
+	authModeOAuth2 authMode = "oauth2"
+	// authModeAccessToken is an oauth2 access token without a refresh token
+	authModeAccessToken authMode = "access_token"
+	// authModeWorkspaceToken is a workspace access token, which can only see the one workspace
+	authModeWorkspaceToken authMode = "workspace_access_token"
+	// authModeRepositoryToken is a repository access token, which can only see the one repo
+	authModeRepositoryToken authMode = "repository_access_token"
+)
+
+// scopes needed by the integration, see https://developer.atlassian.com/cloud/bitbucket/rest/intro/#authentication
+var (
+	exportScopes  = []string{"repository", "pullrequest"}
+	webhookScopes = []string{"repository", "webhook"}
+)
+
+// credentials are the http credentials for the configured auth mode
+type credentials struct {
+	mode       authMode
+	opt        sdk.WithHTTPOption
+	workspace  string
+	repository string
+}
+
+func withBearerToken(token string) sdk.WithHTTPOption {
+	return func(opt *sdk.HTTPOptions) error {
+		opt.Request.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+}
+
+func (g *BitBucketIntegration) getCredentials(logger sdk.Logger, config sdk.Config) (*credentials, error) {
+	if ok, token := config.GetString(string(authModeWorkspaceToken)); ok && token != "" {
+		_, workspace := config.GetString("workspace")
+		if workspace == "" {
+			return nil, errors.New("workspace access token requires the `workspace` slug to be set")
+		}
+		sdk.LogInfo(logger, "using workspace access token", "workspace", workspace)
+		return &credentials{mode: authModeWorkspaceToken, opt: withBearerToken(token), workspace: workspace}, nil
+	}
+	if ok, token := config.GetString(string(authModeRepositoryToken)); ok && token != "" {
+		_, repository := config.GetString("repository")
+		if len(strings.Split(repository, "/")) != 2 {
+			return nil, errors.New("repository access token requires the `repository` full name (workspace/repo) to be set")
+		}
+		sdk.LogInfo(logger, "using repository access token", "repository", repository)
+		return &credentials{mode: authModeRepositoryToken, opt: withBearerToken(token), repository: repository}, nil
+	}
+	if config.BasicAuth != nil {
+		sdk.LogInfo(logger, "using app password")
+		return &credentials{mode: authModeAppPassword, opt: sdk.WithBasicAuth(
+			config.BasicAuth.Username,
+			config.BasicAuth.Password,
+		)}, nil
+	}
+	if config.OAuth2Auth != nil {
+		if config.OAuth2Auth.RefreshToken == nil || g.manager == nil {
+			sdk.LogInfo(logger, "using oauth2 access token")
+			return &credentials{mode: authModeAccessToken, opt: withBearerToken(config.OAuth2Auth.AccessToken)}, nil
+		}
+		sdk.LogInfo(logger, "using oauth2")
+		return &credentials{mode: authModeOAuth2, opt: sdk.WithOAuth2Refresh(
+			g.manager, g.refType,
+			config.OAuth2Auth.AccessToken,
+			*config.OAuth2Auth.RefreshToken,
+		)}, nil
+	}
+	return nil, errors.New("missing authentication")
+}
+
+// hasUser returns true if the credentials belong to a user, access tokens can't call the user apis
+func (c *credentials) hasUser() bool {
+	return c.mode != authModeWorkspaceToken && c.mode != authModeRepositoryToken
+}
+
+// scopeEndpoint is an endpoint the credentials can always read, used to find the granted scopes
+func (c *credentials) scopeEndpoint() string {
+	switch c.mode {
+	case authModeWorkspaceToken:
+		return sdk.JoinURL("workspaces", c.workspace)
+	case authModeRepositoryToken:
+		return sdk.JoinURL("repositories", c.repository)
+	}
+	return "user"
+}
+
+// hasScope returns true if required is covered by the granted scopes, a write or admin scope includes read and pullrequest includes repository
+func hasScope(granted []string, required string) bool {
+	for _, scope := range granted {
+		name := strings.Split(scope, ":")[0]
+		if name == required || (required == "repository" && name == "pullrequest") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkScopes will return an error listing any required scopes the credentials are missing
+func (c *credentials) checkScopes(logger sdk.Logger, a *api.API, required []string) error {
+	granted, ok, err := a.FetchScopes(c.scopeEndpoint())
+	if err != nil {
+		return fmt.Errorf("error checking %s credentials: %w", c.mode, err)
+	}
+	if !ok {
+		sdk.LogDebug(logger, "no scopes returned for credentials, skipping scope check", "mode", c.mode)
+		return nil
+	}
+	var missing []string
+	for _, scope := range required {
+		if !hasScope(granted, scope) {
+			missing = append(missing, scope)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s credentials are missing required scopes: %s (granted: %s)", c.mode, strings.Join(missing, ", "), strings.Join(granted, ", "))
+	}
+	return nil
+}
+
+// fetchWorkSpaces returns the workspaces the credentials can access
+func (c *credentials) fetchWorkSpaces(a *api.API) ([]api.WorkSpacesResponse, error) {
+	switch c.mode {
+	case authModeWorkspaceToken:
+		ws, err := a.FetchWorkSpace(c.workspace)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching workspace: %w", err)
+		}
+		return []api.WorkSpacesResponse{ws}, nil
+	case authModeRepositoryToken:
+		repo, err := a.FetchRepo(c.repository)
+		if err != nil {
+			return nil, fmt.Errorf("error fetching repo: %w", err)
+		}
+		return []api.WorkSpacesResponse{api.ExtractRepoWorkSpace(repo)}, nil
+	}
+	return a.FetchWorkSpaces()
+}
+
+// fetchRepos sends the repos of a workspace the credentials can access to repochan
+func (c *credentials) fetchRepos(a *api.API, team string, updated time.Time, repochan chan<- *sdk.SourceCodeRepo) error {
+	if c.mode != authModeRepositoryToken {
+		return a.FetchRepos(team, updated, repochan)
+	}
+	if !strings.HasPrefix(c.repository, team+"/") {
+		return nil
+	}
+	repo, err := a.FetchRepo(c.repository)
+	if err != nil {
+		return fmt.Errorf("error fetching repo: %w", err)
+	}
+	if repo.UpdatedOn.After(updated) {
+		repochan <- a.ConvertRepo(repo)
+	}
+	return nil
+}
+
+// fetchRepoCount returns the number of repos in a workspace the credentials can access
+func (c *credentials) fetchRepoCount(a *api.API, workspaceSlug string) (int64, error) {
+	if c.mode == authModeRepositoryToken {
+		return 1, nil
+	}
+	return a.FetchRepoCount(workspaceSlug)
+}
@@ -29,15 +29,20 @@ func toConfigAccounts(accounts []*sdk.ConfigAccount) *sdk.ConfigAccounts {
 	return &res
 }
 
-// isUserWorkspace will determine if a workspace is the user's default one
-func isUserWorkspace(ws api.WorkSpacesResponse, user api.MyUser) bool {
-	return ws.Name == user.DisplayName
+// isUserWorkspace will determine if a workspace is the user's personal one, permissions are the user's
+// workspace permissions keyed by workspace uuid
+func isUserWorkspace(ws api.WorkSpacesResponse, user api.MyUser, permissions map[string]string) bool {
+	// the personal workspace is created with the account and shares its uuid
+	if ws.UUID != "" && ws.UUID == user.UUID {
+		return true
+	}
+	// otherwise it's the workspace the user owns which has their username as the slug
+	return user.Username != "" && ws.Slug == user.Username && permissions[ws.UUID] == "owner"
 }
 
-func separateUserWorkSpace(user api.MyUser, workspaces []api.WorkSpacesResponse) (userWorkspace api.WorkSpacesResponse, otherWorkspaces []api.WorkSpacesResponse) {
-	var foundUser bool
+func separateUserWorkSpace(user api.MyUser, permissions map[string]string, workspaces []api.WorkSpacesResponse) (userWorkspace api.WorkSpacesResponse, otherWorkspaces []api.WorkSpacesResponse, foundUser bool) {
 	for _, ws := range workspaces {
-		if isUserWorkspace(ws, user) && !foundUser {
+		if !foundUser && isUserWorkspace(ws, user, permissions) {
 			userWorkspace = ws
 			foundUser = true
 		} else {
@@ -55,51 +60,83 @@ func (g *BitBucketIntegration) AutoConfigure(autoconfig sdk.AutoConfigure) (*sdk
 		return nil, errors.New("no config scope given for autoconfig")
 	}
 	sdk.LogInfo(logger, "autoconfiguring", "scope", config.Scope, "customer_id", autoconfig.CustomerID())
-	a := api.New(logger, g.httpClient, autoconfig.State(), autoconfig.Pipe(), autoconfig.CustomerID(), autoconfig.IntegrationInstanceID(), g.refType, g.getHTTPCredOpts(logger, config))
-	workspaces, err := a.FetchWorkSpaces()
+	creds, err := g.getCredentials(logger, config)
+	if err != nil {
+		return nil, err
+	}
+	a := api.New(logger, g.httpClient, autoconfig.State(), autoconfig.Pipe(), autoconfig.CustomerID(), autoconfig.IntegrationInstanceID(), g.refType, creds.opt)
+	if err := creds.checkScopes(logger, a, exportScopes); err != nil {
+		return nil, err
+	}
+	workspaces, err := creds.fetchWorkSpaces(a)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching user workspaces: %w", err)
 	}
 	var accounts []*sdk.ConfigAccount
-	// if theres just one then it's the user's workspace
-	if len(workspaces) == 1 {
-		switch *config.Scope {
-		case sdk.OrgScope:
-			sdk.LogWarn(logger, "org scope autoconfig only included one workspace", "workspace_name", workspaces[0].Name)
-		case sdk.UserScope:
-			repoCount, err := a.FetchRepoCount(workspaces[0].Slug)
+	if !creds.hasUser() {
+		// access tokens belong to a workspace, not a user
+		for _, ws := range workspaces {
+			repoCount, err := creds.fetchRepoCount(a, ws.Slug)
 			if err != nil {
 				return nil, fmt.Errorf("error getting repo count: %w", err)
 			}
-			accounts = append(accounts, toAccount(workspaces[0], sdk.ConfigAccountTypeUser, repoCount))
-		default:
-			sdk.LogWarn(logger, "unexpected auto config scope", "scope", config.Scope)
+			accounts = append(accounts, toAccount(ws, sdk.ConfigAccountTypeOrg, repoCount))
 		}
-	} else {
-		// need to sort out user workspace from others
-		user, err := a.FetchMyUser()
-		if err != nil {
-			return nil, fmt.Errorf("error fetching user: %w", err)
+		config.Accounts = toConfigAccounts(accounts)
+		return &config, nil
+	}
+	// need to sort out user workspace from others
+	user, err := a.FetchMyUser()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching user: %w", err)
+	}
+	permissions, err := a.FetchWorkSpacePermissions()
+	if err != nil {
+		return nil, fmt.Errorf("error fetching workspace permissions: %w", err)
+	}
+	userWS, otherWS, foundUser := separateUserWorkSpace(user, permissions, workspaces)
+	switch *config.Scope {
+	case sdk.OrgScope:
+		if len(otherWS) == 0 {
+			sdk.LogWarn(logger, "org scope autoconfig found no org workspaces", "workspace_count", len(workspaces))
 		}
-		userWS, otherWS := separateUserWorkSpace(user, workspaces)
-		switch *config.Scope {
-		case sdk.OrgScope:
-			for _, ws := range otherWS {
-				repoCount, err := a.FetchRepoCount(ws.Slug)
-				if err != nil {
-					return nil, fmt.Errorf("error getting repo count: %w", err)
-				}
-				accounts = append(accounts, toAccount(ws, sdk.ConfigAccountTypeOrg, repoCount))
+		for _, ws := range otherWS {
+			repoCount, err := a.FetchRepoCount(ws.Slug)
+			if err != nil {
+				return nil, fmt.Errorf("error getting repo count: %w", err)
 			}
-		case sdk.UserScope:
-			repoCount, err := a.FetchRepoCount(userWS.Slug)
+			accounts = append(accounts, toAccount(ws, sdk.ConfigAccountTypeOrg, repoCount))
+		}
+		followed, err := a.FetchFollowedWorkSpaces()
+		if err != nil {
+			return nil, fmt.Errorf("error fetching followed workspaces: %w", err)
+		}
+		for _, ws := range followed {
+			repoCount, err := a.FetchRepoCount(ws.Slug)
 			if err != nil {
 				return nil, fmt.Errorf("error getting repo count: %w", err)
 			}
-			accounts = append(accounts, toAccount(userWS, sdk.ConfigAccountTypeUser, repoCount))
-		default:
-			sdk.LogWarn(logger, "unexpected auto config scope", "scope", config.Scope)
+			// followed workspaces are exported as thirdparty, so leave them for the user to select
+			account := toAccount(ws, sdk.ConfigAccountTypeOrg, repoCount)
+			account.Selected = sdk.BoolPointer(false)
+			accounts = append(accounts, account)
+		}
+	case sdk.UserScope:
+		if !foundUser {
+			if len(workspaces) != 1 {
+				sdk.LogWarn(logger, "user scope autoconfig couldn't find the user's workspace", "user", user.UUID, "workspace_count", len(workspaces))
+				break
+			}
+			// if theres just one then it's the user's workspace
+			userWS = workspaces[0]
+		}
+		repoCount, err := a.FetchRepoCount(userWS.Slug)
+		if err != nil {
+			return nil, fmt.Errorf("error getting repo count: %w", err)
 		}
+		accounts = append(accounts, toAccount(userWS, sdk.ConfigAccountTypeUser, repoCount))
+	default:
+		sdk.LogWarn(logger, "unexpected auto config scope", "scope", config.Scope)
 	}
 	config.Accounts = toConfigAccounts(accounts)
 	return &config, nil
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/pinpt/bitbucket/internal/api"
+)
+
+func workspace(uuid, slug, name string) api.WorkSpacesResponse {
+	var ws api.WorkSpacesResponse
+	ws.UUID = uuid
+	ws.Slug = slug
+	ws.Name = name
+	return ws
+}
+
+func TestIsUserWorkspace(t *testing.T) {
+	user := api.MyUser{
+		UUID:        "{user}",
+		DisplayName: "Jane Doe",
+		Username:    "jdoe",
+	}
+	tests := []struct {
+		name        string
+		ws          api.WorkSpacesResponse
+		user        api.MyUser
+		permissions map[string]string
+		want        bool
+	}{
+		{"same uuid", workspace("{user}", "jdoe", "Jane Doe"), user, nil, true},
+		{"same uuid after rename", workspace("{user}", "jdoe", "Jane Smith"), user, nil, true},
+		{"org sharing the display name", workspace("{org}", "janedoe-inc", "Jane Doe"), user, map[string]string{"{org}": "owner"}, false},
+		{"owned with username slug", workspace("{ws}", "jdoe", "jdoe"), user, map[string]string{"{ws}": "owner"}, true},
+		{"member with username slug", workspace("{ws}", "jdoe", "jdoe"), user, map[string]string{"{ws}": "member"}, false},
+		{"owned org", workspace("{org}", "acme", "Acme"), user, map[string]string{"{org}": "owner"}, false},
+		{"user without username", workspace("{ws}", "", "Jane Doe"), api.MyUser{UUID: "{user}", DisplayName: "Jane Doe"}, map[string]string{"{ws}": "owner"}, false},
+		{"empty uuids", workspace("", "acme", "Acme"), api.MyUser{DisplayName: "Acme"}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUserWorkspace(tt.ws, tt.user, tt.permissions); got != tt.want {
+				t.Errorf("isUserWorkspace() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSeparateUserWorkSpace(t *testing.T) {
+	user := api.MyUser{UUID: "{user}", DisplayName: "Acme", Username: "jdoe"}
+	tests := []struct {
+		name       string
+		workspaces []api.WorkSpacesResponse
+		wantUser   string
+		wantOthers int
+		wantFound  bool
+	}{
+		{"no workspaces", nil, "", 0, false},
+		{"only personal", []api.WorkSpacesResponse{workspace("{user}", "jdoe", "Acme")}, "jdoe", 0, true},
+		{"personal after org with same name", []api.WorkSpacesResponse{workspace("{org}", "acme", "Acme"), workspace("{user}", "jdoe", "Acme")}, "jdoe", 1, true},
+		{"only orgs", []api.WorkSpacesResponse{workspace("{org}", "acme", "Acme"), workspace("{org2}", "other", "Other")}, "", 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userWS, others, found := separateUserWorkSpace(user, nil, tt.workspaces)
+			if found != tt.wantFound {
+				t.Errorf("separateUserWorkSpace() found = %v, want %v", found, tt.wantFound)
+			}
+			if userWS.Slug != tt.wantUser {
+				t.Errorf("separateUserWorkSpace() user workspace = %q, want %q", userWS.Slug, tt.wantUser)
+			}
+			if len(others) != tt.wantOthers {
+				t.Errorf("separateUserWorkSpace() others = %d, want %d", len(others), tt.wantOthers)
+			}
+		})
+	}
+}
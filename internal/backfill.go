@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pinpt/agent/v4/sdk"
+	"github.com/pinpt/bitbucket/internal/api"
+)
+
+const backfillStateKey = "backfill"
+
+// backfillProgress is how far back the prs of a repo have been exported
+type backfillProgress struct {
+	// Until is the oldest time exported, prs updated after it are done
+	Until time.Time `json:"until"`
+	Done  bool      `json:"done"`
+}
+
+// backfill exports the history of each repo a window at a time, newest first, so the first export is quick and
+// older prs fill in over the following exports. Progress is kept in state by repo ref_id.
+type backfill struct {
+	months int
+	// start is the history limit, there's none when zero
+	start time.Time
+	repos map[string]*backfillProgress
+	mu    sync.Mutex
+}
+
+// newBackfill returns the backfill from state, it's nil when backfill_months isn't set. A historical export starts
+// over from the newest window.
+func newBackfill(state sdk.State, config sdk.Config, start time.Time, historical bool) (*backfill, error) {
+	months := backfillMonths(config)
+	if months == 0 {
+		return nil, nil
+	}
+	b := &backfill{months: months, start: start, repos: make(map[string]*backfillProgress)}
+	if historical {
+		return b, nil
+	}
+	if _, err := state.Get(backfillStateKey, &b.repos); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// pending returns true while a repo has older windows to export, all repos need to be listed until then since
+// most won't have changed since the last export
+func (b *backfill) pending() bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, p := range b.repos {
+		if !p.Done {
+			return true
+		}
+	}
+	return false
+}
+
+// window returns the window before until, which ends at the history limit
+func (b *backfill) window(until time.Time) (from time.Time, done bool) {
+	from = until.AddDate(0, -b.months, 0)
+	if !b.start.IsZero() && !from.After(b.start) {
+		return b.start, true
+	}
+	return from, false
+}
+
+func (b *backfill) progress(repoRefID string) *backfillProgress {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p := b.repos[repoRefID]; p != nil {
+		cp := *p
+		return &cp
+	}
+	return nil
+}
+
+func (b *backfill) set(repoRefID string, p backfillProgress) {
+	b.mu.Lock()
+	b.repos[repoRefID] = &p
+	b.mu.Unlock()
+}
+
+func (b *backfill) save(state sdk.State) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return state.Set(backfillStateKey, b.repos)
+}
+
+// exportPullRequests exports the prs of a repo updated since the last export, which is zero for everything, limited
+// to the history start. With a backfill the first export only gets the newest window and each following export
+// gets the next older one.
+func exportPullRequests(logger sdk.Logger, a *api.API, repo *sdk.SourceCodeRepo, since time.Time, start time.Time, b *backfill, now time.Time) error {
+	if b == nil {
+		from := since
+		if from.Before(start) {
+			from = start
+		}
+		_, err := a.FetchPullRequestsBetween(repo.Name, repo.RefID, from, time.Time{}, since)
+		return err
+	}
+	progress := b.progress(repo.RefID)
+	if since.IsZero() || progress == nil {
+		if !since.IsZero() {
+			// exported before the backfill was turned on so there's nothing older to get
+			b.set(repo.RefID, backfillProgress{Done: true})
+			_, err := a.FetchPullRequestsBetween(repo.Name, repo.RefID, since, time.Time{}, since)
+			return err
+		}
+		from, done := b.window(now)
+		sdk.LogInfo(logger, "backfilling newest prs", "repo", repo.Name, "since", from)
+		if _, err := a.FetchPullRequestsBetween(repo.Name, repo.RefID, from, time.Time{}, time.Time{}); err != nil {
+			return err
+		}
+		b.set(repo.RefID, backfillProgress{Until: from, Done: done})
+		return nil
+	}
+	if _, err := a.FetchPullRequestsBetween(repo.Name, repo.RefID, since, time.Time{}, since); err != nil {
+		return err
+	}
+	if progress.Done {
+		return nil
+	}
+	from, done := b.window(progress.Until)
+	sdk.LogInfo(logger, "backfilling older prs", "repo", repo.Name, "since", from, "until", progress.Until)
+	count, err := a.FetchPullRequestsBetween(repo.Name, repo.RefID, from, progress.Until, time.Time{})
+	if err != nil {
+		return err
+	}
+	if count == 0 && !done {
+		// an empty window is either a quiet period or the end of the history
+		older, err := a.HasPullRequestsBefore(repo.Name, from)
+		if err != nil {
+			return err
+		}
+		done = !older
+	}
+	if done {
+		sdk.LogInfo(logger, "finished backfilling prs", "repo", repo.Name)
+	}
+	b.set(repo.RefID, backfillProgress{Until: from, Done: done})
+	return nil
+}
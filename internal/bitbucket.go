@@ -1,8 +1,12 @@
 package internal
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pinpt/agent/v4/sdk"
@@ -15,16 +19,34 @@ type BitBucketIntegration struct {
 	refType string
 
 	httpClient sdk.HTTPClient
+
+	// ctx is cancelled by Stop and replaced by Start, each export gets its own child context
+	ctx    context.Context
+	cancel context.CancelFunc
+	// stopping is set by Stop, after which no export can start
+	stopping bool
+	exports  map[string]*runningExport
+	running  sync.WaitGroup
+	mu       sync.Mutex
 }
 
 var _ sdk.Integration = (*BitBucketIntegration)(nil)
 
+// NewWithHTTPClient returns an integration using client instead of the agent's http manager, for running outside of the agent
+func NewWithHTTPClient(client sdk.HTTPClient) *BitBucketIntegration {
+	return &BitBucketIntegration{
+		refType:    "bitbucket",
+		httpClient: client,
+	}
+}
+
 // Start is called when the integration is starting up
 func (g *BitBucketIntegration) Start(logger sdk.Logger, config sdk.Config, manager sdk.Manager) error {
 	logger = sdk.LogWith(logger, "pkg", "bitbucket")
 	g.manager = manager
 	g.refType = "bitbucket"
 	g.httpClient = g.manager.HTTPManager().New("https://api.bitbucket.org/2.0", nil)
+	g.resetContext()
 	sdk.LogInfo(logger, "starting")
 	return nil
 }
@@ -44,25 +66,10 @@ func (g *BitBucketIntegration) Dismiss(instance sdk.Instance) error {
 // Stop is called when the integration is shutting down for cleanup
 func (g *BitBucketIntegration) Stop(logger sdk.Logger) error {
 	sdk.LogInfo(logger, "stopping")
+	g.stopExports(logger)
 	return nil
 }
 
-func (g *BitBucketIntegration) getHTTPCredOpts(logger sdk.Logger, config sdk.Config) sdk.WithHTTPOption {
-	if config.BasicAuth != nil {
-		sdk.LogInfo(logger, "using basic auth")
-		return sdk.WithBasicAuth(
-			config.BasicAuth.Username,
-			config.BasicAuth.Password,
-		)
-	}
-	sdk.LogInfo(logger, "using oauth2")
-	return sdk.WithOAuth2Refresh(
-		g.manager, g.refType,
-		config.OAuth2Auth.AccessToken,
-		*config.OAuth2Auth.RefreshToken,
-	)
-}
-
 // Export is called to tell the integration to run an export
 func (g *BitBucketIntegration) Export(export sdk.Export) error {
 	logger := export.Logger()
@@ -80,9 +87,6 @@ func (g *BitBucketIntegration) Export(export sdk.Export) error {
 
 	// Config is any customer specific configuration for this customer
 	config := export.Config()
-	if config.BasicAuth == nil && config.OAuth2Auth == nil {
-		return errors.New("missing authentication")
-	}
 
 	// inst := sdk.NewInstance(config, state, pipe, customerID, export.IntegrationInstanceID())
 	// if err := g.Enroll(*inst); err != nil {
@@ -98,8 +102,17 @@ func (g *BitBucketIntegration) Export(export sdk.Export) error {
 	}
 	sdk.LogInfo(logger, "export starting", "customer", customerID)
 
+	ctx, done, err := g.startExport(logger, export.IntegrationInstanceID())
+	if err != nil {
+		return err
+	}
+	defer done()
+
 	client := g.httpClient
-	creds := g.getHTTPCredOpts(logger, config)
+	creds, err := g.getCredentials(logger, config)
+	if err != nil {
+		return err
+	}
 	var updated time.Time
 	if !export.Historical() {
 		var strTime string
@@ -107,8 +120,21 @@ func (g *BitBucketIntegration) Export(export sdk.Export) error {
 			updated, _ = time.Parse(time.RFC3339Nano, strTime)
 		}
 	}
-	a := api.New(logger, client, state, pipe, customerID, export.IntegrationInstanceID(), g.refType, creds)
-	wss, err := a.FetchWorkSpaces()
+	a := api.New(logger, client, state, pipe, customerID, export.IntegrationInstanceID(), g.refType, creds.opt)
+	a.SetContext(ctx)
+	if err := creds.checkScopes(logger, a, exportScopes); err != nil {
+		return err
+	}
+	bots, err := newBotDetector(config)
+	if err != nil {
+		return err
+	}
+	a.SetBotDetector(bots)
+	a.SetIssueKeyExtractor(newIssueKeyExtractor(config))
+	a.SetCommitterLookup(fetchCommitters(config))
+	limiter := newLimiter(config)
+	a.SetLimiter(limiter)
+	wss, err := creds.fetchWorkSpaces(a)
 	if err != nil {
 		return err
 	}
@@ -124,63 +150,215 @@ func (g *BitBucketIntegration) Export(export sdk.Export) error {
 		}
 	}
 
-	errchan := make(chan error)
-	repochan := make(chan *sdk.SourceCodeRepo)
+	summary, err := newExportSummary(state, ts)
+	if err != nil {
+		return err
+	}
+	failFast := exportFailFast(config)
+	start := exportHistoryStart(config, ts)
+	backfill, err := newBackfill(state, config, start, export.Historical())
+	if err != nil {
+		return err
+	}
+	progress := api.NewProgress(ts)
+	progress.SetWorkSpaces(len(teams))
+	a.SetProgress(progress)
+	stopProgress := reportProgress(logger, progress, progressInterval(config))
+	defer stopProgress()
+
+	// every repo worker and the producer send at most one error
+	concurrency := limiter.Concurrency()
+	errchan := make(chan error, concurrency+1)
+	repochan := make(chan *sdk.SourceCodeRepo, concurrency)
+	var failed int32
+	abort := func(err error) {
+		atomic.StoreInt32(&failed, 1)
+		errchan <- err
+	}
+
+	// exportRepo returns an error when the export can't go on
+	exportRepo := func(r *sdk.SourceCodeRepo) error {
+		if hasInclusions || hasExclusions {
+			name := strings.Split(r.Name, "/")
+			if hasInclusions && !config.Inclusions.Matches(name[0], r.Name) {
+				summary.skip(r.Name, "not included")
+				return nil
+			}
+			if hasExclusions && config.Exclusions.Matches(name[0], r.Name) {
+				summary.skip(r.Name, "excluded")
+				return nil
+			}
+		}
+		team := strings.Split(r.Name, "/")[0]
+		if thirdparty != nil && inslice(team, thirdparty) {
+			r.Affiliation = sdk.SourceCodeRepoAffiliationThirdparty
+		} else {
+			r.Affiliation = sdk.SourceCodeRepoAffiliationOrganization
+		}
+		// a pipe error means nothing else can be sent either
+		if err := pipe.Write(r); err != nil {
+			return err
+		}
+		if err := exportPullRequests(logger, a, r, summary.since(r.Name, updated), start, backfill, ts); err != nil {
+			if failFast || ctx.Err() != nil {
+				return err
+			}
+			sdk.LogWarn(logger, "error exporting repo, will retry", "repo", r.Name, "err", err)
+			summary.retry(r, err)
+			return nil
+		}
+		summary.succeed(r.Name)
+		return nil
+	}
 
 	// =========== repo ============
-	go func() {
-		var count int
-		for r := range repochan {
-			if hasInclusions || hasExclusions {
-				name := strings.Split(r.Name, "/")
-				if hasInclusions && !config.Inclusions.Matches(name[0], r.Name) {
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range repochan {
+				// keep draining so the producer doesn't block after a failure
+				if atomic.LoadInt32(&failed) == 1 {
+					continue
+				}
+				if !summary.start(r.Name) {
 					continue
 				}
-				if hasExclusions && config.Exclusions.Matches(name[0], r.Name) {
+				if err := exportRepo(r); err != nil {
+					abort(err)
 					continue
 				}
+				progress.RepoDone()
 			}
-			team := strings.Split(r.Name, "/")[0]
-			if thirdparty != nil && inslice(team, thirdparty) {
-				r.Affiliation = sdk.SourceCodeRepoAffiliationThirdparty
-			} else {
-				r.Affiliation = sdk.SourceCodeRepoAffiliationOrganization
+		}()
+	}
+	go func() {
+		defer close(repochan)
+		if updated.IsZero() || backfill.pending() {
+			countRepos(logger, a, creds, teams, progress)
+		}
+		since := func(team string) time.Time {
+			if backfill.pending() {
+				return time.Time{}
+			}
+			return summary.since(team, updated)
+		}
+		// failWorkSpace returns false if the export can't go on
+		failWorkSpace := func(team string, err error) bool {
+			if failFast || ctx.Err() != nil {
+				abort(err)
+				return false
 			}
-			if err := pipe.Write(r); err != nil {
-				errchan <- err
+			sdk.LogError(logger, "error exporting workspace", "workspace", team, "err", err)
+			summary.failWorkspace(team, err)
+			return true
+		}
+		// the members of every workspace are fetched before any repo so a user is only departed once they left all
+		// of them, and pr participants are checked against all of them. The repos of a workspace whose members
+		// can't be fetched are still exported, the failure makes its members be fetched in full next time.
+		for _, team := range teams {
+			if atomic.LoadInt32(&failed) == 1 {
 				return
 			}
-			if err := a.FetchPullRequests(r.Name, r.RefID, updated); err != nil {
-				errchan <- err
+			if err := a.FetchUsers(team, since(team)); err != nil {
+				if !failWorkSpace(team, fmt.Errorf("error fetching users: %w", err)) {
+					return
+				}
+			}
+		}
+		if err := a.SyncDepartedMembers(teams); err != nil {
+			abort(err)
+			return
+		}
+		// the repos which failed last time are fetched on their own since an incremental export only lists the repos
+		// updated since the last one, a repo which is also listed is only exported once
+		for _, name := range summary.failedRepos() {
+			if atomic.LoadInt32(&failed) == 1 {
 				return
 			}
-			count++
+			repo, err := a.FetchRepo(name)
+			if err != nil {
+				if errors.Is(err, api.ErrNotFound) {
+					summary.skip(name, "not found")
+					continue
+				}
+				sdk.LogWarn(logger, "error fetching repo which failed last time", "repo", name, "err", err)
+				continue
+			}
+			repochan <- a.ConvertRepo(repo)
 		}
-		sdk.LogDebug(logger, "finished sending repos", "len", count)
-	}()
-	go func() {
 		for _, team := range teams {
-			if err := a.FetchUsers(team, updated); err != nil {
-				sdk.LogError(logger, "error fetching repos", "err", err)
-				errchan <- err
+			if atomic.LoadInt32(&failed) == 1 {
 				return
 			}
-			if err := a.FetchRepos(team, updated, repochan); err != nil {
-				sdk.LogError(logger, "error fetching repos", "err", err)
-				errchan <- err
-				return
+			if err := creds.fetchRepos(a, team, since(team), repochan); err != nil {
+				if !failWorkSpace(team, fmt.Errorf("error fetching repos: %w", err)) {
+					return
+				}
 			}
+			progress.WorkSpaceDone()
 		}
-		errchan <- nil
 	}()
+	wg.Wait()
+	close(errchan)
 
 	if err := <-errchan; err != nil {
+		if ctx.Err() != nil {
+			// checkpoint what finished, updated isn't moved so the rest is fetched next time
+			sdk.LogInfo(logger, "export cancelled", "duration", time.Since(ts))
+			summary.cancel()
+			if err := summary.finish(logger, state); err != nil {
+				sdk.LogError(logger, "error saving export summary", "err", err)
+			}
+			if backfill != nil {
+				if err := backfill.save(state); err != nil {
+					sdk.LogError(logger, "error saving backfill progress", "err", err)
+				}
+			}
+			return ctx.Err()
+		}
 		sdk.LogError(logger, "export finished with error", "err", err)
 		return err
 	}
-	state.Set("updated", time.Now().Format(time.RFC3339Nano))
 
-	close(repochan)
+	// =========== retry ============
+	retries := summary.pending()
+	for attempt := 1; attempt <= exportRetries(config) && len(retries) > 0 && ctx.Err() == nil; attempt++ {
+		sdk.LogInfo(logger, "retrying failed repos", "attempt", attempt, "len", len(retries))
+		var remaining []*repoRetry
+		for _, r := range retries {
+			if err := exportPullRequests(logger, a, r.repo, summary.since(r.repo.Name, updated), start, backfill, ts); err != nil {
+				sdk.LogWarn(logger, "error retrying repo", "repo", r.repo.Name, "attempt", attempt, "err", err)
+				remaining = append(remaining, &repoRetry{r.repo, err})
+				continue
+			}
+			summary.succeed(r.repo.Name)
+		}
+		retries = remaining
+	}
+	summary.fail(retries)
+	if ctx.Err() != nil {
+		summary.cancel()
+	}
+
+	if err := summary.finish(logger, state); err != nil {
+		return err
+	}
+	if backfill != nil {
+		if err := backfill.save(state); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		sdk.LogInfo(logger, "export cancelled", "duration", time.Since(ts))
+		return ctx.Err()
+	}
+	if !summary.ok() {
+		return fmt.Errorf("export failed for all %d repos and %d workspaces", len(summary.Failed), len(summary.FailedWorkspaces))
+	}
+	// repos that failed are refetched in full next time, so the others can move on
+	state.Set("updated", time.Now().Format(time.RFC3339Nano))
 
 	sdk.LogInfo(logger, "export finished", "duration", time.Since(ts))
 
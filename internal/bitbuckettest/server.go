@@ -0,0 +1,215 @@
+// Package bitbuckettest has a fake Bitbucket Cloud api which serves recorded responses, for testing the integration
+// end to end without network access.
+package bitbuckettest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// apiPrefix is the path of the 2.0 api, which is removed before matching responses
+const apiPrefix = "/2.0"
+
+// Response is a recorded response for a request. Query values are matched with path.Match so a value like
+// `updated_on > *` matches any incremental request. When several responses match a request the one matching the
+// most query values wins, and the one added last when that's a tie.
+type Response struct {
+	Method  string            `json:"method,omitempty"`
+	Path    string            `json:"path"`
+	Query   map[string]string `json:"query,omitempty"`
+	Status  int               `json:"status,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is the json response, {{server}} is replaced with the url of the server for next links
+	Body json.RawMessage `json:"body,omitempty"`
+}
+
+func (r Response) matches(req *http.Request, reqpath string) (bool, int) {
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	if method != req.Method || r.Path != reqpath {
+		return false, 0
+	}
+	query := req.URL.Query()
+	for k, v := range r.Query {
+		if ok, _ := path.Match(v, query.Get(k)); !ok {
+			return false, 0
+		}
+	}
+	return true, len(r.Query)
+}
+
+// Server is a fake Bitbucket Cloud api, responses only have the fields in a request's fields param
+type Server struct {
+	*httptest.Server
+	t         testing.TB
+	responses []Response
+	requests  []string
+	mu        sync.Mutex
+}
+
+// NewServer starts a Server with the responses in the fixture files, each is a json array of Response. The server
+// is closed when the test finishes.
+func NewServer(t testing.TB, fixtures ...string) *Server {
+	t.Helper()
+	s := &Server{t: t}
+	for _, fn := range fixtures {
+		buf, err := ioutil.ReadFile(fn)
+		if err != nil {
+			t.Fatalf("error reading fixture: %s", err)
+		}
+		var responses []Response
+		if err := json.Unmarshal(buf, &responses); err != nil {
+			t.Fatalf("error parsing fixture %s: %s", fn, err)
+		}
+		s.responses = append(s.responses, responses...)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// APIURL returns the url of the 2.0 api
+func (s *Server) APIURL() string {
+	return s.URL + apiPrefix
+}
+
+// Handle adds a response, which takes priority over an existing one for the same request
+func (s *Server) Handle(r Response) {
+	s.mu.Lock()
+	s.responses = append(s.responses, r)
+	s.mu.Unlock()
+}
+
+// Requests returns the requests made so far as method, path and sorted query, such as
+// `GET /repositories/acme?sort=-updated_on`
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// Requested returns the requests made so far for a method and path, with their query
+func (s *Server) Requested(method, reqpath string) []string {
+	var found []string
+	prefix := method + " " + reqpath
+	for _, r := range s.Requests() {
+		if r == prefix || strings.HasPrefix(r, prefix+"?") {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+// Reset forgets the requests made so far
+func (s *Server) Reset() {
+	s.mu.Lock()
+	s.requests = nil
+	s.mu.Unlock()
+}
+
+func (s *Server) serve(w http.ResponseWriter, req *http.Request) {
+	reqpath := strings.TrimPrefix(req.URL.Path, apiPrefix)
+	record := req.Method + " " + reqpath
+	if req.URL.RawQuery != "" {
+		// url.Values.Encode sorts by key
+		record += "?" + req.URL.Query().Encode()
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, record)
+	var found *Response
+	best := -1
+	for i := range s.responses {
+		if ok, score := s.responses[i].matches(req, reqpath); ok && score >= best {
+			found = &s.responses[i]
+			best = score
+		}
+	}
+	s.mu.Unlock()
+	if found == nil {
+		s.t.Errorf("unexpected request to fake bitbucket: %s", record)
+		writeJSON(w, http.StatusNotFound, nil, []byte(`{"type":"error","error":{"message":"no fixture for request"}}`))
+		return
+	}
+	status := found.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	body := []byte(strings.ReplaceAll(string(found.Body), "{{server}}", s.URL))
+	if fields := req.URL.Query().Get("fields"); fields != "" && status < http.StatusBadRequest && len(body) > 0 {
+		filtered, err := filterFields(body, fields)
+		if err != nil {
+			s.t.Errorf("error filtering fields of fixture for %s: %s", record, err)
+		} else {
+			body = filtered
+		}
+	}
+	writeJSON(w, status, found.Headers, body)
+}
+
+// fieldTree is a fields param as a tree of field names, a field without children is kept whole
+type fieldTree map[string]fieldTree
+
+// filterFields keeps only the fields in a fields param like bitbucket's partial responses do, so tests fail when a
+// field the integration uses isn't requested. Only comma separated paths are supported, not wildcards or the + and
+// - forms.
+func filterFields(body []byte, fields string) ([]byte, error) {
+	tree := make(fieldTree)
+	for _, field := range strings.Split(fields, ",") {
+		node := tree
+		for _, name := range strings.Split(strings.TrimSpace(field), ".") {
+			if node[name] == nil {
+				node[name] = make(fieldTree)
+			}
+			node = node[name]
+		}
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(tree.filter(v))
+}
+
+func (t fieldTree) filter(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		filtered := make(map[string]interface{})
+		for name, child := range t {
+			if fv, ok := val[name]; ok {
+				if len(child) == 0 {
+					filtered[name] = fv
+				} else {
+					filtered[name] = child.filter(fv)
+				}
+			}
+		}
+		return filtered
+	case []interface{}:
+		filtered := make([]interface{}, len(val))
+		for i, item := range val {
+			filtered[i] = t.filter(item)
+		}
+		return filtered
+	}
+	return v
+}
+
+func writeJSON(w http.ResponseWriter, status int, headers map[string]string, body []byte) {
+	for k, v := range headers {
+		w.Header().Set(k, v)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if len(body) > 0 {
+		fmt.Fprint(w, string(body))
+	}
+}
@@ -0,0 +1,680 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pinpt/agent/v4/sdk"
+	"github.com/pinpt/bitbucket/internal/bitbuckettest"
+	"github.com/pinpt/bitbucket/internal/local"
+)
+
+const testCustomerID = "1234"
+const testIntegrationInstanceID = "5678"
+
+// harness runs the integration against a fake bitbucket with in memory state and pipe
+type harness struct {
+	server      *bitbuckettest.Server
+	integration *BitBucketIntegration
+	config      sdk.Config
+	state       *local.State
+	pipe        *local.MemoryPipe
+	logger      sdk.Logger
+}
+
+func newHarness(t *testing.T, sets ...string) *harness {
+	t.Helper()
+	server := bitbuckettest.NewServer(t, "testdata/bitbucket/export.json")
+	config, err := local.LoadConfig("", append([]string{`basic_auth={"username":"alice","password":"secret"}`}, sets...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &harness{
+		server:      server,
+		integration: NewWithHTTPClient(local.NewHTTPClient(server.APIURL(), server.Client())),
+		config:      config,
+		state:       local.NewMemoryState(),
+		pipe:        local.NewMemoryPipe(),
+		logger:      local.NewLogger(ioutil.Discard),
+	}
+}
+
+func (h *harness) export(historical bool) error {
+	return h.integration.Export(local.NewExport(h.logger, h.config, h.state, h.pipe, testCustomerID, testIntegrationInstanceID, historical))
+}
+
+func (h *harness) webhook(t *testing.T, fn string) error {
+	t.Helper()
+	buf, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload struct {
+		Event string          `json:"event"`
+		Body  json.RawMessage `json:"body"`
+	}
+	if err := json.Unmarshal(buf, &payload); err != nil {
+		t.Fatal(err)
+	}
+	return h.integration.ProcessWebHook(h.logger, h.config, h.state, h.pipe, testCustomerID, testIntegrationInstanceID, payload.Event, payload.Body)
+}
+
+// collect appends the objects written to the pipe with the element type of out, a pointer to a slice of models
+func collect(pipe *local.MemoryPipe, out interface{}) {
+	slice := reflect.ValueOf(out).Elem()
+	for _, object := range pipe.Objects() {
+		v := reflect.ValueOf(object)
+		if v.Type() == slice.Type().Elem() {
+			slice.Set(reflect.Append(slice, v))
+		}
+	}
+}
+
+func TestExportHistorical(t *testing.T) {
+	h := newHarness(t)
+	var logs bytes.Buffer
+	h.logger = local.NewLogger(&logs)
+	if err := h.export(true); err != nil {
+		t.Fatalf("export failed: %s", err)
+	}
+
+	var repos []*sdk.SourceCodeRepo
+	collect(h.pipe, &repos)
+	if len(repos) != 2 {
+		t.Fatalf("expected 2 repos, got %d", len(repos))
+	}
+	visibility := make(map[string]sdk.SourceCodeRepoVisibility)
+	for _, repo := range repos {
+		visibility[repo.Name] = repo.Visibility
+		if repo.Affiliation != sdk.SourceCodeRepoAffiliationOrganization {
+			t.Errorf("expected %s to be an organization repo, got %v", repo.Name, repo.Affiliation)
+		}
+	}
+	if visibility["acme/api"] != sdk.SourceCodeRepoVisibilityPrivate {
+		t.Errorf("expected acme/api to be private, got %v", visibility["acme/api"])
+	}
+	if visibility["acme/web"] != sdk.SourceCodeRepoVisibilityPublic {
+		t.Errorf("expected acme/web to be public, got %v", visibility["acme/web"])
+	}
+
+	// members are on two pages
+	var users []*sdk.SourceCodeUser
+	collect(h.pipe, &users)
+	members := make(map[string]bool)
+	for _, user := range users {
+		if user.Member {
+			members[user.RefID] = true
+		}
+	}
+	if !members["alice-id"] || !members["bob-id"] {
+		t.Errorf("expected alice and bob to be members, got %v", members)
+	}
+
+	// prs are on two pages
+	var prs []*sdk.SourceCodePullRequest
+	collect(h.pipe, &prs)
+	byID := make(map[string]*sdk.SourceCodePullRequest)
+	for _, pr := range prs {
+		byID[pr.RefID] = pr
+	}
+	if len(byID) != 2 {
+		t.Fatalf("expected prs 1 and 2, got %d prs", len(byID))
+	}
+	merged := byID["1"]
+	if merged.Status != sdk.SourceCodePullRequestStatusMerged {
+		t.Errorf("expected pr 1 to be merged, got %v", merged.Status)
+	}
+	if merged.MergedByRefID != "bob-id" {
+		t.Errorf("expected pr 1 to be merged by bob, got %q", merged.MergedByRefID)
+	}
+	if !reflect.DeepEqual(merged.CommitShas, []string{"a1b2c3d4e5f6"}) {
+		t.Errorf("unexpected commit shas for pr 1: %v", merged.CommitShas)
+	}
+	if !reflect.DeepEqual(merged.IssueKeys, []string{"PLAT-12"}) {
+		t.Errorf("unexpected issue keys for pr 1: %v", merged.IssueKeys)
+	}
+	// commits for pr 2 are not found, which means it has none
+	if open := byID["2"]; open.Status != sdk.SourceCodePullRequestStatusOpen || len(open.CommitShas) != 0 {
+		t.Errorf("expected pr 2 to be open without commits, got %v with %v", open.Status, open.CommitShas)
+	}
+
+	var comments []*sdk.SourceCodePullRequestComment
+	collect(h.pipe, &comments)
+	if len(comments) != 1 || comments[0].RefID != "10" || comments[0].UserRefID != "bob-id" {
+		t.Errorf("expected comment 10 by bob, got %v", comments)
+	}
+
+	var reviews []*sdk.SourceCodePullRequestReview
+	collect(h.pipe, &reviews)
+	if len(reviews) != 1 || reviews[0].UserRefID != "bob-id" || reviews[0].State != sdk.SourceCodePullRequestReviewStateApproved {
+		t.Errorf("expected an approval by bob, got %v", reviews)
+	}
+
+	var commits []*sdk.SourceCodePullRequestCommit
+	collect(h.pipe, &commits)
+	if len(commits) != 1 || commits[0].Sha != "a1b2c3d4e5f6" {
+		t.Errorf("expected commit a1b2c3d4e5f6, got %v", commits)
+	}
+
+	if !h.state.Exists("updated") {
+		t.Error("expected the export time to be saved")
+	}
+	var summary exportSummary
+	if ok, err := h.state.Get(exportSummaryStateKey, &summary); !ok || err != nil {
+		t.Fatalf("expected an export summary, got %v %v", ok, err)
+	}
+	if len(summary.Succeeded) != 2 || len(summary.Failed) != 0 {
+		t.Errorf("expected both repos to succeed, got %v and %v", summary.Succeeded, summary.Failed)
+	}
+	var progress string
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "export progress") {
+			progress = line
+		}
+	}
+	for _, done := range []string{`workspaces_done="1"`, `repos="2"`, `repos_done="2"`, `prs="2"`, `prs_done="2"`} {
+		if !strings.Contains(progress, done) {
+			t.Errorf("expected the last progress to have %s, got %s", done, progress)
+		}
+	}
+}
+
+func TestExportIncremental(t *testing.T) {
+	h := newHarness(t)
+	if err := h.export(true); err != nil {
+		t.Fatalf("historical export failed: %s", err)
+	}
+	h.pipe.Reset()
+	h.server.Reset()
+
+	// only acme/api and its pr 2 changed since the last export
+	h.server.Handle(bitbuckettest.Response{
+		Path:  "/repositories/acme",
+		Query: map[string]string{"q": "updated_on > *"},
+		Body:  json.RawMessage(`{"values": [{"type": "repository", "uuid": "{a9100000-0000-0000-0000-000000000001}", "full_name": "acme/api", "is_private": true, "updated_on": "2020-06-03T10:00:00.000000+00:00"}]}`),
+	})
+	h.server.Handle(bitbuckettest.Response{
+		Path:  "/repositories/acme/api/pullrequests",
+		Query: map[string]string{"q": "updated_on > *"},
+		Body:  json.RawMessage(`{"values": [{"type": "pullrequest", "id": 2, "title": "new endpoint", "state": "OPEN", "source": {"branch": {"name": "bob/endpoint"}}, "author": {"account_id": "bob-id"}, "created_on": "2020-06-02T09:00:00+00:00", "updated_on": "2099-01-01T00:00:00+00:00"}]}`),
+	})
+	if err := h.export(false); err != nil {
+		t.Fatalf("incremental export failed: %s", err)
+	}
+
+	for _, path := range []string{"/repositories/acme", "/repositories/acme/api/pullrequests"} {
+		requests := h.server.Requested(http.MethodGet, path)
+		if len(requests) != 1 || !strings.Contains(requests[0], "q=updated_on") {
+			t.Errorf("expected one incremental request for %s, got %v", path, requests)
+		}
+	}
+	if requests := h.server.Requested(http.MethodGet, "/repositories/acme/web/pullrequests"); len(requests) != 0 {
+		t.Errorf("expected unchanged acme/web to be skipped, got %v", requests)
+	}
+	var prs []*sdk.SourceCodePullRequest
+	collect(h.pipe, &prs)
+	if len(prs) != 1 || prs[0].RefID != "2" || prs[0].Title != "new endpoint" {
+		t.Errorf("expected only the updated pr 2, got %v", prs)
+	}
+}
+
+func TestExportSkipsUnchangedPullRequestParts(t *testing.T) {
+	h := newHarness(t)
+	prs := func(state, hash string) json.RawMessage {
+		return json.RawMessage(`{"values": [
+			{"type": "pullrequest", "id": 1, "title": "PLAT-12 add health check", "state": "` + state + `", "comment_count": 1, "source": {"branch": {"name": "feature/health"}, "commit": {"hash": "` + hash + `"}}, "author": {"account_id": "alice-id"}, "created_on": "2020-06-01T09:00:00+00:00", "updated_on": "2099-01-01T00:00:00+00:00"},
+			{"type": "pullrequest", "id": 2, "title": "new endpoint", "state": "OPEN", "comment_count": 0, "source": {"branch": {"name": "bob/endpoint"}, "commit": {"hash": "c3c3c3c3c3c3"}}, "author": {"account_id": "bob-id"}, "created_on": "2020-06-02T09:00:00+00:00", "updated_on": "2099-01-01T00:00:00+00:00"}
+		]}`)
+	}
+	// only open prs keep a fingerprint, pr 2 is at the commit it has in the fixtures
+	h.server.Handle(bitbuckettest.Response{
+		Path: "/repositories/acme/api/pullrequests",
+		Body: json.RawMessage(strings.Replace(string(prs("OPEN", "a1b2c3d4e5f6")), "c3c3c3c3c3c3", "b2b2b2b2b2b2", 1)),
+	})
+	if err := h.export(true); err != nil {
+		t.Fatalf("historical export failed: %s", err)
+	}
+	lastComment := func(path string) int {
+		var n int
+		for _, r := range h.server.Requested(http.MethodGet, path) {
+			if strings.Contains(r, "pagelen=1") {
+				n++
+			}
+		}
+		return n
+	}
+	// pr 1 was updated without new comments or commits, pr 2 was pushed to
+	h.pipe.Reset()
+	h.server.Reset()
+	h.server.Handle(bitbuckettest.Response{
+		Path:  "/repositories/acme/api/pullrequests",
+		Query: map[string]string{"q": "updated_on > *"},
+		Body:  prs("OPEN", "a1b2c3d4e5f6"),
+	})
+	if err := h.export(false); err != nil {
+		t.Fatalf("incremental export failed: %s", err)
+	}
+	for _, path := range []string{
+		"/repositories/acme/api/pullrequests/1/comments",
+		"/repositories/acme/api/pullrequests/2/comments",
+	} {
+		if requests := h.server.Requested(http.MethodGet, path); len(requests) != 1 || lastComment(path) != 1 {
+			t.Errorf("expected only the last comment of %s to be checked, got %v", path, requests)
+		}
+	}
+	if requests := h.server.Requested(http.MethodGet, "/repositories/acme/api/pullrequests/1/commits"); len(requests) != 0 {
+		t.Errorf("expected the unchanged commits of pr 1 to be skipped, got %v", requests)
+	}
+	if requests := h.server.Requested(http.MethodGet, "/repositories/acme/api/pullrequests/2/commits"); len(requests) != 1 {
+		t.Errorf("expected the commits of pr 2 to be fetched, got %v", requests)
+	}
+	// the pr keeps the commits from the last export
+	var sent []*sdk.SourceCodePullRequest
+	collect(h.pipe, &sent)
+	for _, pr := range sent {
+		if pr.RefID == "1" && !reflect.DeepEqual(pr.CommitShas, []string{"a1b2c3d4e5f6"}) {
+			t.Errorf("expected pr 1 to keep its commit, got %v", pr.CommitShas)
+		}
+	}
+	if len(sent) != 2 {
+		t.Errorf("expected both prs to be sent, got %d", len(sent))
+	}
+
+	// the comment on pr 1 was edited, which doesn't change the count, and a commit was pushed to it
+	h.pipe.Reset()
+	h.server.Reset()
+	h.server.Handle(bitbuckettest.Response{
+		Path:  "/repositories/acme/api/pullrequests",
+		Query: map[string]string{"q": "updated_on > *"},
+		Body:  prs("OPEN", "f0f0f0f0f0f0"),
+	})
+	h.server.Handle(bitbuckettest.Response{
+		Path: "/repositories/acme/api/pullrequests/1/comments",
+		Body: json.RawMessage(`{"values": [
+			{"type": "pullrequest_comment", "id": 10, "content": {"raw": "looks good now", "html": "<p>looks good now</p>"}, "user": {"type": "user", "account_id": "bob-id", "display_name": "Bob Jones"}, "created_on": "2020-06-01T11:00:00+00:00", "updated_on": "2099-01-01T00:00:00+00:00"}
+		]}`),
+	})
+	h.server.Handle(bitbuckettest.Response{
+		Path: "/repositories/acme/api/pullrequests/1/commits",
+		Body: json.RawMessage(`{"values": [
+			{"type": "commit", "hash": "f0f0f0f0f0f0", "message": "fix health check", "date": "2099-01-01T00:00:00+00:00", "author": {"raw": "Alice Smith <alice@acme.example>", "user": {"type": "user", "account_id": "alice-id", "display_name": "Alice Smith"}}}
+		]}`),
+	})
+	if err := h.export(false); err != nil {
+		t.Fatalf("incremental export failed: %s", err)
+	}
+	if requests := h.server.Requested(http.MethodGet, "/repositories/acme/api/pullrequests/1/comments"); len(requests) != 2 {
+		t.Errorf("expected the edited comment on pr 1 to be fetched, got %v", requests)
+	}
+	var comments []*sdk.SourceCodePullRequestComment
+	collect(h.pipe, &comments)
+	if len(comments) != 1 || comments[0].RefID != "10" {
+		t.Errorf("expected the edited comment to be sent, got %v", comments)
+	}
+	sent = nil
+	collect(h.pipe, &sent)
+	for _, pr := range sent {
+		if pr.RefID == "1" && !reflect.DeepEqual(pr.CommitShas, []string{"f0f0f0f0f0f0", "a1b2c3d4e5f6"}) {
+			t.Errorf("expected the new commit to be added to the ones from the last export, got %v", pr.CommitShas)
+		}
+	}
+
+	// pr 1 was merged, its fingerprint is dropped so the next update fetches everything again
+	for i := 0; i < 2; i++ {
+		h.server.Reset()
+		h.server.Handle(bitbuckettest.Response{
+			Path:  "/repositories/acme/api/pullrequests",
+			Query: map[string]string{"q": "updated_on > *"},
+			Body:  prs("MERGED", "f0f0f0f0f0f0"),
+		})
+		if err := h.export(false); err != nil {
+			t.Fatalf("incremental export failed: %s", err)
+		}
+	}
+	if requests := h.server.Requested(http.MethodGet, "/repositories/acme/api/pullrequests/1/commits"); len(requests) != 1 {
+		t.Errorf("expected the commits of the merged pr 1 to be fetched, got %v", requests)
+	}
+	if lastComment("/repositories/acme/api/pullrequests/1/comments") != 0 {
+		t.Errorf("expected all the comments of the merged pr 1 to be fetched")
+	}
+}
+
+func TestExportCommitters(t *testing.T) {
+	// the author date is 2020-06-01T08:00:00Z
+	const authored = int64(1590998400000)
+	t.Run("off", func(t *testing.T) {
+		h := newHarness(t)
+		if err := h.export(true); err != nil {
+			t.Fatalf("export failed: %s", err)
+		}
+		if requests := h.server.Requested(http.MethodGet, "/repositories/acme/api/commit/a1b2c3d4e5f6"); len(requests) != 0 {
+			t.Errorf("expected the committer not to be looked up, got %v", requests)
+		}
+		var commits []*sdk.SourceCodePullRequestCommit
+		collect(h.pipe, &commits)
+		if len(commits) != 1 || commits[0].CommitterRefID != "alice-id" || commits[0].CreatedDate.Epoch != authored {
+			t.Errorf("expected alice to be the committer on the author date, got %v", commits)
+		}
+	})
+	t.Run("rebased", func(t *testing.T) {
+		h := newHarness(t, "fetch_committers=true")
+		h.server.Handle(bitbuckettest.Response{
+			Path: "/repositories/acme/api/commit/a1b2c3d4e5f6",
+			Body: json.RawMessage(`{"type": "commit", "hash": "a1b2c3d4e5f6", "date": "2020-06-01T08:00:00+00:00", "author": {"raw": "Alice Smith <alice@acme.example>"}, "committer": {"raw": "Carol <carol@acme.example>", "date": "2020-06-03T08:00:00+00:00"}}`),
+		})
+		if err := h.export(true); err != nil {
+			t.Fatalf("export failed: %s", err)
+		}
+		var commits []*sdk.SourceCodePullRequestCommit
+		collect(h.pipe, &commits)
+		if len(commits) != 1 || commits[0].CommitterRefID != sdk.Hash("carol@acme.example") || commits[0].AuthorRefID != "alice-id" {
+			t.Fatalf("expected carol to be the committer, got %v", commits)
+		}
+		if commits[0].CreatedDate.Epoch != authored {
+			t.Errorf("expected the author date, got %d", commits[0].CreatedDate.Epoch)
+		}
+	})
+	t.Run("not found", func(t *testing.T) {
+		h := newHarness(t, "fetch_committers=true")
+		h.server.Handle(bitbuckettest.Response{
+			Path:   "/repositories/acme/api/commit/a1b2c3d4e5f6",
+			Status: http.StatusNotFound,
+			Body:   json.RawMessage(`{"type": "error", "error": {"message": "commit not found"}}`),
+		})
+		if err := h.export(true); err != nil {
+			t.Fatalf("export failed: %s", err)
+		}
+		var commits []*sdk.SourceCodePullRequestCommit
+		collect(h.pipe, &commits)
+		if len(commits) != 1 || commits[0].CommitterRefID != "alice-id" {
+			t.Errorf("expected the author to be used as the committer, got %v", commits)
+		}
+	})
+}
+
+func TestExportForbiddenMembers(t *testing.T) {
+	h := newHarness(t)
+	h.server.Handle(bitbuckettest.Response{
+		Path:   "/workspaces/acme/members",
+		Status: http.StatusForbidden,
+		Body:   json.RawMessage(`{"type": "error", "error": {"message": "forbidden"}}`),
+	})
+	if err := h.export(true); err != nil {
+		t.Fatalf("export failed: %s", err)
+	}
+	var users []*sdk.SourceCodeUser
+	collect(h.pipe, &users)
+	for _, user := range users {
+		if user.Member {
+			t.Errorf("expected no members when they can't be listed, got %s", user.RefID)
+		}
+	}
+	var prs []*sdk.SourceCodePullRequest
+	collect(h.pipe, &prs)
+	if len(prs) != 2 {
+		t.Errorf("expected prs to still be exported, got %d", len(prs))
+	}
+}
+
+func TestExportMembersFailed(t *testing.T) {
+	h := newHarness(t)
+	h.server.Handle(bitbuckettest.Response{
+		Path:   "/workspaces/acme/members",
+		Status: http.StatusInternalServerError,
+		Body:   json.RawMessage(`{"type": "error", "error": {"message": "something went wrong"}}`),
+	})
+	if err := h.export(true); err != nil {
+		t.Fatalf("export failed: %s", err)
+	}
+	var prs []*sdk.SourceCodePullRequest
+	collect(h.pipe, &prs)
+	if len(prs) != 2 {
+		t.Errorf("expected the repos of the workspace to still be exported, got %d prs", len(prs))
+	}
+	var summary exportSummary
+	if _, err := h.state.Get(exportSummaryStateKey, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.FailedWorkspaces) != 1 || summary.FailedWorkspaces[0].Name != "acme" || !strings.Contains(summary.FailedWorkspaces[0].Reason, "error fetching users") {
+		t.Errorf("expected the users of acme to be failed, got %v", summary.FailedWorkspaces)
+	}
+	if len(summary.Succeeded) != 2 {
+		t.Errorf("expected both repos to be exported, got %v", summary.Succeeded)
+	}
+}
+
+func TestExportMembersOfAnotherWorkSpace(t *testing.T) {
+	h := newHarness(t)
+	h.server.Handle(bitbuckettest.Response{
+		Path:  "/workspaces",
+		Query: map[string]string{"role": "member"},
+		Body: json.RawMessage(`{"values": [
+			{"type": "workspace", "uuid": "{ac3e0000-0000-0000-0000-000000000001}", "slug": "acme", "name": "Acme", "is_private": true},
+			{"type": "workspace", "uuid": "{910b0000-0000-0000-0000-000000000002}", "slug": "globex", "name": "Globex", "is_private": true}
+		]}`),
+	})
+	// bob reviews and comments on acme prs but is only a member of globex
+	h.server.Handle(bitbuckettest.Response{
+		Path: "/workspaces/acme/members",
+		Body: json.RawMessage(`{"values": [{"type": "workspace_membership", "user": {"type": "user", "account_id": "alice-id", "display_name": "Alice Smith"}}]}`),
+	})
+	h.server.Handle(bitbuckettest.Response{
+		Path: "/workspaces/globex/members",
+		Body: json.RawMessage(`{"values": [{"type": "workspace_membership", "user": {"type": "user", "account_id": "bob-id", "display_name": "Bob Jones"}}]}`),
+	})
+	h.server.Handle(bitbuckettest.Response{
+		Path: "/repositories/globex",
+		Body: json.RawMessage(`{"size": 0, "values": []}`),
+	})
+	if err := h.export(true); err != nil {
+		t.Fatalf("export failed: %s", err)
+	}
+	var users []*sdk.SourceCodeUser
+	collect(h.pipe, &users)
+	var found bool
+	for _, user := range users {
+		if user.RefID != "bob-id" {
+			continue
+		}
+		found = true
+		if !user.Member {
+			t.Error("expected bob not to be sent as a non member of acme")
+		}
+	}
+	if !found {
+		t.Error("expected bob to be sent as a member of globex")
+	}
+}
+
+func TestExportFailingRepo(t *testing.T) {
+	h := newHarness(t)
+	h.server.Handle(bitbuckettest.Response{
+		Path:   "/repositories/acme/web/pullrequests",
+		Status: http.StatusInternalServerError,
+		Body:   json.RawMessage(`{"type": "error", "error": {"message": "something went wrong"}}`),
+	})
+	if err := h.export(true); err != nil {
+		t.Fatalf("expected the export to finish without acme/web, got %s", err)
+	}
+	// the first attempt and the 2 default retries
+	if requests := h.server.Requested(http.MethodGet, "/repositories/acme/web/pullrequests"); len(requests) != 3 {
+		t.Errorf("expected acme/web to be tried 3 times, got %d", len(requests))
+	}
+	var summary exportSummary
+	if _, err := h.state.Get(exportSummaryStateKey, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(summary.Succeeded, []string{"acme/api"}) {
+		t.Errorf("expected acme/api to succeed, got %v", summary.Succeeded)
+	}
+	if len(summary.Failed) != 1 || summary.Failed[0].Name != "acme/web" || summary.Failed[0].Reason == "" {
+		t.Errorf("expected acme/web to fail with a reason, got %v", summary.Failed)
+	}
+}
+
+func TestExportRefetchesFailedRepo(t *testing.T) {
+	h := newHarness(t)
+	h.server.Handle(bitbuckettest.Response{
+		Path:   "/repositories/acme/web/pullrequests",
+		Status: http.StatusInternalServerError,
+		Body:   json.RawMessage(`{"type": "error", "error": {"message": "something went wrong"}}`),
+	})
+	if err := h.export(true); err != nil {
+		t.Fatalf("historical export failed: %s", err)
+	}
+	h.server.Reset()
+
+	// acme/web hasn't changed so it isn't listed, and it still can't be read
+	h.server.Handle(bitbuckettest.Response{
+		Path:  "/repositories/acme",
+		Query: map[string]string{"q": "updated_on > *"},
+		Body:  json.RawMessage(`{"values": []}`),
+	})
+	h.server.Handle(bitbuckettest.Response{
+		Path:   "/repositories/acme/web",
+		Status: http.StatusInternalServerError,
+		Body:   json.RawMessage(`{"type": "error", "error": {"message": "something went wrong"}}`),
+	})
+	if err := h.export(false); err != nil {
+		t.Fatalf("incremental export failed: %s", err)
+	}
+	var summary exportSummary
+	if _, err := h.state.Get(exportSummaryStateKey, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Failed) != 1 || summary.Failed[0].Name != "acme/web" {
+		t.Fatalf("expected acme/web to still be failed, got %v", summary.Failed)
+	}
+	h.server.Reset()
+
+	// once it can be read it's fetched in full even though the listing doesn't have it
+	h.server.Handle(bitbuckettest.Response{
+		Path: "/repositories/acme/web",
+		Body: json.RawMessage(`{"type": "repository", "uuid": "{3eb00000-0000-0000-0000-000000000002}", "full_name": "acme/web", "is_private": false, "updated_on": "2020-06-01T10:00:00.000000+00:00"}`),
+	})
+	h.server.Handle(bitbuckettest.Response{
+		Path: "/repositories/acme/web/pullrequests",
+		Body: json.RawMessage(`{"values": []}`),
+	})
+	if err := h.export(false); err != nil {
+		t.Fatalf("incremental export failed: %s", err)
+	}
+	requests := h.server.Requested(http.MethodGet, "/repositories/acme/web/pullrequests")
+	if len(requests) != 1 || strings.Contains(requests[0], "q=updated_on") {
+		t.Errorf("expected the prs of acme/web to be fetched in full, got %v", requests)
+	}
+	summary = exportSummary{}
+	if _, err := h.state.Get(exportSummaryStateKey, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Failed) != 0 || !reflect.DeepEqual(summary.Succeeded, []string{"acme/web"}) {
+		t.Errorf("expected only acme/web to be exported and nothing to fail, got %v and %v", summary.Succeeded, summary.Failed)
+	}
+}
+
+func TestExportFailFast(t *testing.T) {
+	h := newHarness(t, "fail_fast=true")
+	h.server.Handle(bitbuckettest.Response{
+		Path:   "/repositories/acme/web/pullrequests",
+		Status: http.StatusInternalServerError,
+		Body:   json.RawMessage(`{"type": "error", "error": {"message": "something went wrong"}}`),
+	})
+	if err := h.export(true); err == nil {
+		t.Fatal("expected the export to fail")
+	}
+	if h.state.Exists("updated") {
+		t.Error("expected the export time not to be saved for a failed export")
+	}
+}
+
+func TestExportAfterStop(t *testing.T) {
+	h := newHarness(t)
+	if err := h.integration.Stop(h.logger); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.export(true); err != errStopping {
+		t.Fatalf("expected an export after stop to be refused, got %v", err)
+	}
+	// Start resets the context Stop cancelled
+	h.integration.resetContext()
+	if err := h.export(true); err != nil {
+		t.Fatalf("expected an export after restarting to work, got %s", err)
+	}
+}
+
+func TestExportHistoryLimit(t *testing.T) {
+	h := newHarness(t, "history_months=12")
+	if err := h.export(true); err != nil {
+		t.Fatalf("export failed: %s", err)
+	}
+	requests := h.server.Requested(http.MethodGet, "/repositories/acme/api/pullrequests")
+	if len(requests) != 2 || !strings.Contains(requests[0], "q=updated_on") {
+		t.Errorf("expected prs to be limited to the last 12 months, got %v", requests)
+	}
+	// prs in the window get all of their comments
+	if requests := h.server.Requested(http.MethodGet, "/repositories/acme/api/pullrequests/1/comments"); len(requests) != 1 || strings.Contains(requests[0], "q=") {
+		t.Errorf("expected all comments for pr 1, got %v", requests)
+	}
+}
+
+func TestExportBackfill(t *testing.T) {
+	h := newHarness(t, "backfill_months=6")
+	if err := h.export(true); err != nil {
+		t.Fatalf("first export failed: %s", err)
+	}
+	requests := h.server.Requested(http.MethodGet, "/repositories/acme/api/pullrequests")
+	if len(requests) == 0 || !strings.Contains(requests[0], "q=updated_on") || strings.Contains(requests[0], "AND") {
+		t.Errorf("expected the first export to only get the newest window, got %v", requests)
+	}
+	var progress map[string]backfillProgress
+	if _, err := h.state.Get(backfillStateKey, &progress); err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) != 2 || progress["{a9100000-0000-0000-0000-000000000001}"].Done {
+		t.Fatalf("expected backfill progress for both repos, got %v", progress)
+	}
+
+	// nothing changed and there's nothing older, which finishes the backfill
+	h.server.Reset()
+	empty := json.RawMessage(`{"values": []}`)
+	for _, q := range []string{"updated_on > *", "updated_on <= *", "updated_on > * AND updated_on <= *"} {
+		h.server.Handle(bitbuckettest.Response{Path: "/repositories/acme/api/pullrequests", Query: map[string]string{"q": q}, Body: empty})
+	}
+	if err := h.export(false); err != nil {
+		t.Fatalf("second export failed: %s", err)
+	}
+	// all repos are listed while backfilling, not only the ones changed since the last export
+	if requests := h.server.Requested(http.MethodGet, "/repositories/acme"); len(requests) != 1 || strings.Contains(requests[0], "q=") {
+		t.Errorf("expected all repos to be listed, got %v", requests)
+	}
+	var window bool
+	for _, r := range h.server.Requested(http.MethodGet, "/repositories/acme/api/pullrequests") {
+		window = window || strings.Contains(r, "AND")
+	}
+	if !window {
+		t.Error("expected the second export to get the next older window")
+	}
+	progress = nil
+	if _, err := h.state.Get(backfillStateKey, &progress); err != nil {
+		t.Fatal(err)
+	}
+	for repo, p := range progress {
+		if !p.Done {
+			t.Errorf("expected backfill of %s to be done", repo)
+		}
+	}
+
+	h.server.Reset()
+	h.server.Handle(bitbuckettest.Response{Path: "/repositories/acme", Query: map[string]string{"q": "updated_on > *"}, Body: empty})
+	if err := h.export(false); err != nil {
+		t.Fatalf("third export failed: %s", err)
+	}
+	if requests := h.server.Requested(http.MethodGet, "/repositories/acme"); len(requests) != 1 || !strings.Contains(requests[0], "q=updated_on") {
+		t.Errorf("expected only changed repos once the backfill is done, got %v", requests)
+	}
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+// stopTimeout is how long Stop waits for running exports to checkpoint
+const stopTimeout = 30 * time.Second
+
+// runningExport is an export in progress for an integration instance
+type runningExport struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// errStopping is returned for an export started after Stop
+var errStopping = errors.New("integration is stopping")
+
+// resetContext starts a new context for the integration, a restarted integration can't use the one Stop cancelled
+func (g *BitBucketIntegration) resetContext() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+	g.stopping = false
+}
+
+// context returns the context for work outside of an export, which is cancelled by Stop
+func (g *BitBucketIntegration) context() context.Context {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.contextLocked()
+}
+
+func (g *BitBucketIntegration) contextLocked() context.Context {
+	if g.ctx == nil {
+		g.ctx, g.cancel = context.WithCancel(context.Background())
+	}
+	return g.ctx
+}
+
+// startExport returns the context for an export of the instance, cancelling and waiting for any export of it which is
+// still running. done must be called when the export returns. An error is returned once Stop was called.
+func (g *BitBucketIntegration) startExport(logger sdk.Logger, integrationInstanceID string) (ctx context.Context, done func(), err error) {
+	g.mu.Lock()
+	for {
+		if g.stopping {
+			g.mu.Unlock()
+			return nil, nil, errStopping
+		}
+		prev := g.exports[integrationInstanceID]
+		if prev == nil {
+			break
+		}
+		sdk.LogInfo(logger, "cancelling export superseded by a new one")
+		prev.cancel()
+		g.mu.Unlock()
+		<-prev.done
+		g.mu.Lock()
+	}
+	ctx, cancel := context.WithCancel(g.contextLocked())
+	e := &runningExport{cancel: cancel, done: make(chan struct{})}
+	if g.exports == nil {
+		g.exports = make(map[string]*runningExport)
+	}
+	g.exports[integrationInstanceID] = e
+	// added under mu while not stopping, so it can't race with the Wait in stopExports
+	g.running.Add(1)
+	g.mu.Unlock()
+	return ctx, func() {
+		cancel()
+		g.mu.Lock()
+		delete(g.exports, integrationInstanceID)
+		g.mu.Unlock()
+		close(e.done)
+		g.running.Done()
+	}, nil
+}
+
+// stopExports cancels everything in progress, refuses new exports and waits for running exports to checkpoint
+func (g *BitBucketIntegration) stopExports(logger sdk.Logger) {
+	g.mu.Lock()
+	g.stopping = true
+	g.contextLocked()
+	g.cancel()
+	g.mu.Unlock()
+	stopped := make(chan struct{})
+	go func() {
+		g.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(stopTimeout):
+		sdk.LogWarn(logger, "timed out waiting for exports to stop", "timeout", stopTimeout)
+	}
+}
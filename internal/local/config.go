@@ -0,0 +1,45 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+// LoadConfig returns the config from the json file fn, which is skipped when empty, overridden by sets in the same
+// key=value form as the agent's --set. Values which aren't json are used as strings.
+func LoadConfig(fn string, sets []string) (sdk.Config, error) {
+	var config sdk.Config
+	values := make(map[string]interface{})
+	if fn != "" {
+		buf, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return config, fmt.Errorf("error reading config: %w", err)
+		}
+		if err := json.Unmarshal(buf, &values); err != nil {
+			return config, fmt.Errorf("error parsing config: %w", err)
+		}
+	}
+	for _, set := range sets {
+		tok := strings.SplitN(set, "=", 2)
+		if len(tok) != 2 {
+			return config, fmt.Errorf("invalid config %q, expected key=value", set)
+		}
+		var v interface{}
+		if err := json.Unmarshal([]byte(tok[1]), &v); err != nil {
+			v = tok[1]
+		}
+		values[tok[0]] = v
+	}
+	buf, err := json.Marshal(values)
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(buf, &config); err != nil {
+		return config, fmt.Errorf("error parsing config: %w", err)
+	}
+	return config, nil
+}
@@ -0,0 +1,80 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+// DirPipe is a sdk.Pipe which writes each model type as lines of json to its own file in a directory, such as
+// sourcecode.PullRequest.json
+type DirPipe struct {
+	dir   string
+	files map[string]*os.File
+	encs  map[string]*json.Encoder
+	mu    sync.Mutex
+}
+
+var _ sdk.Pipe = (*DirPipe)(nil)
+
+// NewDirPipe returns a DirPipe writing to dir, which is created if missing. Files are truncated on the first write
+// of their model type.
+func NewDirPipe(dir string) (*DirPipe, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating pipe directory: %w", err)
+	}
+	return &DirPipe{
+		dir:   dir,
+		files: make(map[string]*os.File),
+		encs:  make(map[string]*json.Encoder),
+	}, nil
+}
+
+// Write will write the object to the file for its model type
+func (p *DirPipe) Write(object sdk.Model) error {
+	name := ModelName(object)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	enc := p.encs[name]
+	if enc == nil {
+		f, err := os.Create(filepath.Join(p.dir, name+".json"))
+		if err != nil {
+			return fmt.Errorf("error creating pipe file: %w", err)
+		}
+		p.files[name] = f
+		enc = json.NewEncoder(f)
+		p.encs[name] = enc
+	}
+	return enc.Encode(object)
+}
+
+// Flush will sync the files to disk
+func (p *DirPipe) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, f := range p.files {
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close will close the files
+func (p *DirPipe) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var err error
+	for name, f := range p.files {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(p.files, name)
+		delete(p.encs, name)
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+package local
+
+import (
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+// Export is a sdk.Export for running an export without an agent
+type Export struct {
+	logger                sdk.Logger
+	config                sdk.Config
+	state                 sdk.State
+	pipe                  sdk.Pipe
+	customerID            string
+	integrationInstanceID string
+	historical            bool
+}
+
+var _ sdk.Export = (*Export)(nil)
+
+// NewExport returns an Export, historical exports ignore the last export time in state and fetch everything
+func NewExport(logger sdk.Logger, config sdk.Config, state sdk.State, pipe sdk.Pipe, customerID, integrationInstanceID string, historical bool) *Export {
+	return &Export{
+		logger:                logger,
+		config:                config,
+		state:                 state,
+		pipe:                  pipe,
+		customerID:            customerID,
+		integrationInstanceID: integrationInstanceID,
+		historical:            historical,
+	}
+}
+
+// Logger returns the logger
+func (e *Export) Logger() sdk.Logger { return e.logger }
+
+// Config returns the integration config
+func (e *Export) Config() sdk.Config { return e.config }
+
+// State returns the state
+func (e *Export) State() sdk.State { return e.state }
+
+// Pipe returns the pipe for the exported records
+func (e *Export) Pipe() sdk.Pipe { return e.pipe }
+
+// CustomerID returns the customer id
+func (e *Export) CustomerID() string { return e.customerID }
+
+// IntegrationInstanceID returns the integration instance id
+func (e *Export) IntegrationInstanceID() string { return e.integrationInstanceID }
+
+// Historical returns true when everything should be fetched
+func (e *Export) Historical() bool { return e.historical }
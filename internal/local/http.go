@@ -0,0 +1,88 @@
+package local
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+// HTTPClient is a sdk.HTTPClient using the standard library client, without the agent's retry handling
+type HTTPClient struct {
+	url    string
+	client *http.Client
+}
+
+var _ sdk.HTTPClient = (*HTTPClient)(nil)
+
+// NewHTTPClient returns a HTTPClient for the base url
+func NewHTTPClient(url string, client *http.Client) *HTTPClient {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPClient{url: url, client: client}
+}
+
+func (c *HTTPClient) exec(method string, data io.Reader, out interface{}, options []sdk.WithHTTPOption) (*sdk.HTTPResponse, error) {
+	req, err := http.NewRequest(method, c.url, data)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	opts := &sdk.HTTPOptions{Request: req}
+	for _, opt := range options {
+		if err := opt(opts); err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.client.Do(opts.Request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	res := &sdk.HTTPResponse{StatusCode: resp.StatusCode, Headers: resp.Header}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return res, &sdk.HTTPError{StatusCode: resp.StatusCode, Body: strings.NewReader(string(buf))}
+	}
+	if out != nil && len(buf) > 0 {
+		if err := json.Unmarshal(buf, out); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+// Get will call a HTTP GET method
+func (c *HTTPClient) Get(out interface{}, options ...sdk.WithHTTPOption) (*sdk.HTTPResponse, error) {
+	return c.exec(http.MethodGet, nil, out, options)
+}
+
+// Post will call a HTTP POST method passing the data in the io.Reader
+func (c *HTTPClient) Post(data io.Reader, out interface{}, options ...sdk.WithHTTPOption) (*sdk.HTTPResponse, error) {
+	return c.exec(http.MethodPost, data, out, options)
+}
+
+// Put will call a HTTP PUT method passing the data in the io.Reader
+func (c *HTTPClient) Put(data io.Reader, out interface{}, options ...sdk.WithHTTPOption) (*sdk.HTTPResponse, error) {
+	return c.exec(http.MethodPut, data, out, options)
+}
+
+// Patch will call a HTTP PATCH method passing the data in the io.Reader
+func (c *HTTPClient) Patch(data io.Reader, out interface{}, options ...sdk.WithHTTPOption) (*sdk.HTTPResponse, error) {
+	return c.exec(http.MethodPatch, data, out, options)
+}
+
+// Delete will call a HTTP DELETE method
+func (c *HTTPClient) Delete(out interface{}, options ...sdk.WithHTTPOption) (*sdk.HTTPResponse, error) {
+	return c.exec(http.MethodDelete, nil, out, options)
+}
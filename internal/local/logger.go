@@ -0,0 +1,42 @@
+package local
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+// Logger is a sdk.Logger writing logfmt style lines
+type Logger struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+var _ sdk.Logger = (*Logger)(nil)
+
+// NewLogger returns a Logger writing to w
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{w: w}
+}
+
+// Log will write the key value pairs as a line
+func (l *Logger) Log(keyvals ...interface{}) error {
+	var sb strings.Builder
+	sb.WriteString("ts=" + time.Now().Format(time.RFC3339))
+	for i := 0; i < len(keyvals); i += 2 {
+		var val interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			val = keyvals[i+1]
+		}
+		fmt.Fprintf(&sb, " %v=%q", keyvals[i], fmt.Sprint(val))
+	}
+	sb.WriteString("\n")
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := io.WriteString(l.w, sb.String())
+	return err
+}
@@ -0,0 +1,52 @@
+package local
+
+import (
+	"sync"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+// MemoryPipe is a sdk.Pipe which keeps the objects written in memory, for tests
+type MemoryPipe struct {
+	objects []sdk.Model
+	mu      sync.Mutex
+}
+
+var _ sdk.Pipe = (*MemoryPipe)(nil)
+
+// NewMemoryPipe returns an empty MemoryPipe
+func NewMemoryPipe() *MemoryPipe {
+	return &MemoryPipe{}
+}
+
+// Write will keep the object
+func (p *MemoryPipe) Write(object sdk.Model) error {
+	p.mu.Lock()
+	p.objects = append(p.objects, object)
+	p.mu.Unlock()
+	return nil
+}
+
+// Objects returns the objects written in order
+func (p *MemoryPipe) Objects() []sdk.Model {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]sdk.Model(nil), p.objects...)
+}
+
+// Reset removes the objects written so far
+func (p *MemoryPipe) Reset() {
+	p.mu.Lock()
+	p.objects = nil
+	p.mu.Unlock()
+}
+
+// Flush does nothing
+func (p *MemoryPipe) Flush() error {
+	return nil
+}
+
+// Close does nothing
+func (p *MemoryPipe) Close() error {
+	return nil
+}
@@ -0,0 +1,66 @@
+// Package local has file backed implementations of the agent sdk interfaces for running the integration without an agent
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+// Pipe is a sdk.Pipe which writes each object as a line of json to a file
+type Pipe struct {
+	f   *os.File
+	enc *json.Encoder
+	mu  sync.Mutex
+}
+
+var _ sdk.Pipe = (*Pipe)(nil)
+
+type pipeRecord struct {
+	Model  string    `json:"model"`
+	Object sdk.Model `json:"object"`
+}
+
+// NewPipe returns a Pipe that writes to fn, truncating the file if it exists
+func NewPipe(fn string) (*Pipe, error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		return nil, fmt.Errorf("error creating pipe file: %w", err)
+	}
+	return &Pipe{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+// ModelName returns the package qualified name of a model, such as sourcecode.PullRequest
+func ModelName(object sdk.Model) string {
+	t := reflect.TypeOf(object)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return path.Base(t.PkgPath()) + "." + t.Name()
+}
+
+// Write will write the object to the file
+func (p *Pipe) Write(object sdk.Model) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.enc.Encode(pipeRecord{ModelName(object), object})
+}
+
+// Flush will sync the file to disk
+func (p *Pipe) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.f.Sync()
+}
+
+// Close will close the file
+func (p *Pipe) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.f.Close()
+}
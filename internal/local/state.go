@@ -0,0 +1,105 @@
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+// State is a sdk.State backed by a json file on disk
+type State struct {
+	fn     string
+	values map[string]json.RawMessage
+	mu     sync.Mutex
+}
+
+var _ sdk.State = (*State)(nil)
+
+// NewMemoryState returns a State which is only kept in memory, for tests
+func NewMemoryState() *State {
+	return &State{values: make(map[string]json.RawMessage)}
+}
+
+// NewState returns a State loaded from fn, a missing file will start with an empty state
+func NewState(fn string) (*State, error) {
+	s := &State{
+		fn:     fn,
+		values: make(map[string]json.RawMessage),
+	}
+	buf, err := ioutil.ReadFile(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("error reading state file: %w", err)
+	}
+	if len(buf) > 0 {
+		if err := json.Unmarshal(buf, &s.values); err != nil {
+			return nil, fmt.Errorf("error parsing state file: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Set a value by key
+func (s *State) Set(key string, value interface{}) error {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.values[key] = buf
+	s.mu.Unlock()
+	return nil
+}
+
+// SetWithExpires sets a value by key, expiration is ignored for local state
+func (s *State) SetWithExpires(key string, value interface{}, expiry time.Duration) error {
+	return s.Set(key, value)
+}
+
+// Get a value by key and decode it into out, returns false if the key is not found
+func (s *State) Get(key string, out interface{}) (bool, error) {
+	s.mu.Lock()
+	buf, ok := s.values[key]
+	s.mu.Unlock()
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(buf, out)
+}
+
+// Exists returns true if the key exists
+func (s *State) Exists(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.values[key]
+	return ok
+}
+
+// Delete a value by key
+func (s *State) Delete(key string) error {
+	s.mu.Lock()
+	delete(s.values, key)
+	s.mu.Unlock()
+	return nil
+}
+
+// Flush writes the state to disk, it does nothing for a memory state
+func (s *State) Flush() error {
+	if s.fn == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	buf, err := json.MarshalIndent(s.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.fn, buf, 0644)
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pinpt/agent/v4/sdk"
+	"github.com/pinpt/bitbucket/internal/api"
+)
+
+// configList returns the comma separated values of a config key
+func configList(config sdk.Config, key string) []string {
+	ok, val := config.GetString(key)
+	if !ok {
+		return nil
+	}
+	var vals []string
+	for _, v := range strings.Split(val, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			vals = append(vals, v)
+		}
+	}
+	return vals
+}
+
+// newBotDetector returns the bot detection from the config, bot_name_patterns replaces the default patterns when set
+func newBotDetector(config sdk.Config) (*api.BotDetector, error) {
+	patterns := api.DefaultBotNamePatterns
+	if custom := configList(config, "bot_name_patterns"); custom != nil {
+		patterns = custom
+	}
+	return api.NewBotDetector(
+		configList(config, "bot_account_ids"),
+		patterns,
+		configList(config, "bot_email_domains"),
+	)
+}
+
+// newIssueKeyExtractor returns the jira issue key matching from the config, issue_project_keys limits it to those projects
+func newIssueKeyExtractor(config sdk.Config) *api.IssueKeyExtractor {
+	return api.NewIssueKeyExtractor(configList(config, "issue_project_keys"))
+}
+
+// fetchCommitters returns true when fetch_committers is set, which gets the committer of each pr commit with one more
+// request per commit so rebased and cherry-picked commits are credited to who committed them
+func fetchCommitters(config sdk.Config) bool {
+	ok, v := config.GetBool("fetch_committers")
+	return ok && v
+}
+
+// newLimiter returns the request budget for an export from the config. concurrency, which defaults to 10, is how many
+// requests run at once and how many repos and prs are processed at once, requests_per_hour optionally limits the rate.
+func newLimiter(config sdk.Config) *api.Limiter {
+	concurrency := int64(10)
+	if ok, v := config.GetInt("concurrency"); ok && v > 0 {
+		concurrency = v
+	}
+	var perHour int64
+	if ok, v := config.GetInt("requests_per_hour"); ok && v > 0 {
+		perHour = v
+	}
+	return api.NewLimiter(int(concurrency), int(perHour))
+}
+
+// exportFailFast returns true when fail_fast is set, which stops the export at the first repo error instead of
+// retrying it at the end
+func exportFailFast(config sdk.Config) bool {
+	ok, v := config.GetBool("fail_fast")
+	return ok && v
+}
+
+// exportRetries returns how many times to retry repos which failed, export_retries defaults to 2
+func exportRetries(config sdk.Config) int {
+	if ok, v := config.GetInt("export_retries"); ok && v >= 0 {
+		return int(v)
+	}
+	return 2
+}
+
+// exportHistoryStart returns the oldest pr update to export, history_months limits it to the last N months and it's
+// zero without a limit
+func exportHistoryStart(config sdk.Config, now time.Time) time.Time {
+	if ok, v := config.GetInt("history_months"); ok && v > 0 {
+		return now.AddDate(0, -int(v), 0)
+	}
+	return time.Time{}
+}
+
+// backfillMonths returns the size of each backfill window from backfill_months, it's zero when not backfilling
+func backfillMonths(config sdk.Config) int {
+	if ok, v := config.GetInt("backfill_months"); ok && v > 0 {
+		return int(v)
+	}
+	return 0
+}
+
+// progressInterval returns how often to report export progress, progress_seconds defaults to 60
+func progressInterval(config sdk.Config) time.Duration {
+	if ok, v := config.GetInt("progress_seconds"); ok && v > 0 {
+		return time.Duration(v) * time.Second
+	}
+	return time.Minute
+}
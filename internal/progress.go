@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/pinpt/agent/v4/sdk"
+	"github.com/pinpt/bitbucket/internal/api"
+)
+
+// reportProgress logs the progress of an export every interval until stop is called, which reports it one last time.
+// The logs are the only place it's reported since the sdk has no progress hook.
+func reportProgress(logger sdk.Logger, progress *api.Progress, interval time.Duration) (stop func()) {
+	report := func() {
+		s := progress.Snapshot()
+		sdk.LogInfo(logger, "export progress",
+			"workspaces", s.WorkSpaces, "workspaces_done", s.WorkSpacesDone,
+			"repos", s.Repos, "repos_done", s.ReposDone,
+			"prs", s.PullRequests, "prs_done", s.PullRequestsDone,
+			"elapsed", s.Elapsed.Round(time.Second), "eta", s.ETA.Round(time.Second))
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				report()
+			case <-done:
+				report()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// countRepos counts the repos of every workspace before listing them so progress has a total from the start, the
+// totals returned while listing repos are used instead when any workspace can't be counted. A single workspace isn't
+// counted since the first page of repos has its total.
+func countRepos(logger sdk.Logger, a *api.API, creds *credentials, teams []string, progress *api.Progress) {
+	if len(teams) < 2 {
+		return
+	}
+	var total int64
+	for _, team := range teams {
+		count, err := creds.fetchRepoCount(a, team)
+		if err != nil {
+			sdk.LogWarn(logger, "error counting repos for progress", "workspace", team, "err", err)
+			return
+		}
+		total += count
+	}
+	progress.CountRepos(total)
+}
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+const exportSummaryStateKey = "export_summary"
+
+// exportResult is a repo or workspace in the export summary, with the reason it failed or was skipped
+type exportResult struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// exportSummary records what happened to each repo and workspace in an export so one broken repo doesn't stop the
+// others. It's saved to state so the next incremental export can refetch whatever failed.
+type exportSummary struct {
+	Started          time.Time      `json:"started"`
+	Finished         time.Time      `json:"finished"`
+	Succeeded        []string       `json:"succeeded"`
+	Failed           []exportResult `json:"failed"`
+	Skipped          []exportResult `json:"skipped"`
+	FailedWorkspaces []exportResult `json:"failed_workspaces"`
+	Cancelled        bool           `json:"cancelled"`
+
+	previous map[string]bool
+	// lastFailed and lastFailedWorkspaces are from the last export
+	lastFailed           []exportResult
+	lastFailedWorkspaces []exportResult
+	// started are the repos which were sent to be exported, so a repo listed twice is only exported once
+	started map[string]bool
+	// carried are the failed repos from the last export which weren't tried again
+	carried int
+	retries []*repoRetry
+	mu      sync.Mutex
+}
+
+// repoRetry is a repo which failed and will be tried again at the end of the export
+type repoRetry struct {
+	repo *sdk.SourceCodeRepo
+	err  error
+}
+
+// newExportSummary returns an empty summary which remembers what failed in the last export in state
+func newExportSummary(state sdk.State, started time.Time) (*exportSummary, error) {
+	s := &exportSummary{Started: started, previous: make(map[string]bool), started: make(map[string]bool)}
+	var prev exportSummary
+	ok, err := state.Get(exportSummaryStateKey, &prev)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		s.lastFailed = prev.Failed
+		s.lastFailedWorkspaces = prev.FailedWorkspaces
+		for _, f := range prev.Failed {
+			s.previous[f.Name] = true
+		}
+		for _, f := range prev.FailedWorkspaces {
+			s.previous[f.Name] = true
+		}
+	}
+	return s, nil
+}
+
+// since returns the time to fetch changes from for a repo or workspace, which is everything when it failed last time
+func (s *exportSummary) since(name string, updated time.Time) time.Time {
+	if s.previous[name] {
+		return time.Time{}
+	}
+	return updated
+}
+
+// failedRepos returns the names of the repos which failed in the last export
+func (s *exportSummary) failedRepos() []string {
+	names := make([]string, len(s.lastFailed))
+	for i, f := range s.lastFailed {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// start returns false if the repo was already started in this export
+func (s *exportSummary) start(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started[name] {
+		return false
+	}
+	s.started[name] = true
+	return true
+}
+
+func (s *exportSummary) succeed(name string) {
+	s.mu.Lock()
+	s.Succeeded = append(s.Succeeded, name)
+	s.mu.Unlock()
+}
+
+func (s *exportSummary) skip(name string, reason string) {
+	s.mu.Lock()
+	s.Skipped = append(s.Skipped, exportResult{name, reason})
+	s.mu.Unlock()
+}
+
+func (s *exportSummary) failWorkspace(name string, err error) {
+	s.mu.Lock()
+	s.FailedWorkspaces = append(s.FailedWorkspaces, exportResult{name, err.Error()})
+	s.mu.Unlock()
+}
+
+// retry queues a failed repo to be tried again
+func (s *exportSummary) retry(repo *sdk.SourceCodeRepo, err error) {
+	s.mu.Lock()
+	s.retries = append(s.retries, &repoRetry{repo, err})
+	s.mu.Unlock()
+}
+
+// pending returns the repos waiting to be retried and clears them
+func (s *exportSummary) pending() []*repoRetry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	retries := s.retries
+	s.retries = nil
+	return retries
+}
+
+// fail records the repos which failed every retry
+func (s *exportSummary) fail(retries []*repoRetry) {
+	s.mu.Lock()
+	for _, r := range retries {
+		s.Failed = append(s.Failed, exportResult{r.repo.Name, r.err.Error()})
+	}
+	s.mu.Unlock()
+}
+
+// cancel marks the export as stopped before it finished, keeping the workspaces which failed last time so they're
+// still refetched in full
+func (s *exportSummary) cancel() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Cancelled = true
+	s.FailedWorkspaces = append(s.FailedWorkspaces, s.lastFailedWorkspaces...)
+}
+
+// carryForward keeps the repos which failed last time and didn't succeed, fail or get skipped in this export, such as
+// when the export was cancelled or the repo couldn't be fetched, so they're still refetched in full next time
+func (s *exportSummary) carryForward() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[string]bool)
+	for _, name := range s.Succeeded {
+		seen[name] = true
+	}
+	for _, f := range append(append([]exportResult{}, s.Failed...), s.Skipped...) {
+		seen[f.Name] = true
+	}
+	for _, f := range s.lastFailed {
+		if !seen[f.Name] {
+			s.Failed = append(s.Failed, f)
+			s.carried++
+		}
+	}
+}
+
+// ok returns false when nothing was exported but something failed in this export
+func (s *exportSummary) ok() bool {
+	return len(s.Succeeded) > 0 || (len(s.Failed) == s.carried && len(s.FailedWorkspaces) == 0)
+}
+
+// finish logs the summary and saves it to state
+func (s *exportSummary) finish(logger sdk.Logger, state sdk.State) error {
+	s.carryForward()
+	s.Finished = time.Now()
+	for _, f := range s.FailedWorkspaces {
+		sdk.LogError(logger, "workspace failed to export", "workspace", f.Name, "reason", f.Reason)
+	}
+	for _, f := range s.Failed {
+		sdk.LogError(logger, "repo failed to export", "repo", f.Name, "reason", f.Reason)
+	}
+	for _, f := range s.Skipped {
+		sdk.LogDebug(logger, "repo skipped", "repo", f.Name, "reason", f.Reason)
+	}
+	sdk.LogInfo(logger, "export summary", "succeeded", len(s.Succeeded), "failed", len(s.Failed), "skipped", len(s.Skipped), "failed_workspaces", len(s.FailedWorkspaces), "cancelled", s.Cancelled)
+	return state.Set(exportSummaryStateKey, s)
+}
@@ -12,29 +12,72 @@ func (g *BitBucketIntegration) Validate(validate sdk.Validate) (map[string]inter
 	logger := validate.Logger()
 	config := validate.Config()
 	sdk.LogInfo(logger, "validate", "customer_id", validate.CustomerID())
+	creds, err := g.getCredentials(logger, config)
+	if err != nil {
+		return nil, err
+	}
 	// FIXME(robin): make api okay with nil state/pipe
-	a := api.New(logger, g.httpClient, nil, nil, validate.CustomerID(), validate.IntegrationInstanceID(), g.refType, g.getHTTPCredOpts(logger, config))
-	workspaces, err := a.FetchWorkSpaces()
+	a := api.New(logger, g.httpClient, nil, nil, validate.CustomerID(), validate.IntegrationInstanceID(), g.refType, creds.opt)
+	if err := creds.checkScopes(logger, a, exportScopes); err != nil {
+		return nil, err
+	}
+	workspaces, err := creds.fetchWorkSpaces(a)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching user workspaces: %w", err)
 	}
-	currentUser, err := a.FetchMyUser()
+	var currentUser api.MyUser
+	var permissions map[string]string
+	if creds.hasUser() {
+		currentUser, err = a.FetchMyUser()
+		if err != nil {
+			return nil, fmt.Errorf("error fetching current user: %w", err)
+		}
+		permissions, err = a.FetchWorkSpacePermissions()
+		if err != nil {
+			return nil, fmt.Errorf("error fetching workspace permissions: %w", err)
+		}
+	}
+	granted, scoped, err := a.FetchScopes(creds.scopeEndpoint())
 	if err != nil {
-		return nil, fmt.Errorf("error fetching current user: %w", err)
+		return nil, fmt.Errorf("error fetching credential scopes: %w", err)
+	}
+	hooks := api.HookAccess{
+		Scope:      !scoped || hasScope(granted, "webhook"),
+		NeedsAdmin: creds.hasUser(),
 	}
 	var accounts []*sdk.ConfigAccount
+	capabilities := make(map[string]api.Capabilities)
 	for _, workspace := range workspaces {
-		count, err := a.FetchRepoCount(workspace.Slug)
+		count, err := creds.fetchRepoCount(a, workspace.Slug)
 		if err != nil {
 			return nil, fmt.Errorf("error getting count of repos for workspace (%s): %w", workspace.Slug, err)
 		}
 		accType := sdk.ConfigAccountTypeOrg
-		if isUserWorkspace(workspace, currentUser) {
+		if creds.hasUser() && isUserWorkspace(workspace, currentUser, permissions) {
 			accType = sdk.ConfigAccountTypeUser
 		}
 		accounts = append(accounts, toAccount(workspace, accType, count))
+		// only check the workspaces which are selected
+		if config.Accounts != nil {
+			if acc, ok := (*config.Accounts)[workspace.UUID]; ok && acc.Selected != nil && !*acc.Selected {
+				continue
+			}
+		}
+		var reponame string
+		if creds.mode == authModeRepositoryToken {
+			reponame = creds.repository
+		}
+		caps, err := a.FetchCapabilities(workspace.Slug, reponame, hooks)
+		if err != nil {
+			return nil, fmt.Errorf("error checking capabilities for workspace (%s): %w", workspace.Slug, err)
+		}
+		if missing := caps.Missing(); len(missing) > 0 {
+			sdk.LogWarn(logger, "credentials are missing capabilities for workspace", "workspace", workspace.Slug, "missing", missing)
+		}
+		capabilities[workspace.Slug] = caps
 	}
 	return map[string]interface{}{
-		"accounts": accounts,
+		"accounts":     accounts,
+		"capabilities": capabilities,
 	}, nil
 }
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pinpt/agent/v4/sdk"
+)
+
+func TestWebHookRepoUpdated(t *testing.T) {
+	h := newHarness(t)
+	if err := h.webhook(t, "testdata/bitbucket/webhooks/repo_updated.json"); err != nil {
+		t.Fatal(err)
+	}
+	var repos []*sdk.SourceCodeRepo
+	collect(h.pipe, &repos)
+	if len(repos) != 1 || repos[0].Name != "acme/api" || repos[0].Visibility != sdk.SourceCodeRepoVisibilityPrivate {
+		t.Errorf("expected private repo acme/api, got %v", repos)
+	}
+	if requests := h.server.Requests(); len(requests) != 0 {
+		t.Errorf("expected no api requests, got %v", requests)
+	}
+}
+
+func TestWebHookPullRequestCreated(t *testing.T) {
+	h := newHarness(t)
+	if err := h.webhook(t, "testdata/bitbucket/webhooks/pullrequest_created.json"); err != nil {
+		t.Fatal(err)
+	}
+	if requests := h.server.Requested(http.MethodGet, "/repositories/acme/api/pullrequests/1/commits"); len(requests) != 1 {
+		t.Errorf("expected the pr commits to be fetched once, got %v", requests)
+	}
+	var prs []*sdk.SourceCodePullRequest
+	collect(h.pipe, &prs)
+	if len(prs) != 1 {
+		t.Fatalf("expected 1 pr, got %d", len(prs))
+	}
+	if pr := prs[0]; pr.RefID != "1" || pr.Status != sdk.SourceCodePullRequestStatusOpen || len(pr.CommitShas) != 1 {
+		t.Errorf("expected open pr 1 with a commit, got %+v", pr)
+	}
+	var requests []*sdk.SourceCodePullRequestReviewRequest
+	collect(h.pipe, &requests)
+	if len(requests) != 1 || requests[0].RequestedReviewerRefID != "bob-id" {
+		t.Errorf("expected a review request for bob, got %v", requests)
+	}
+}
+
+func TestWebHookPullRequestChangesRequest(t *testing.T) {
+	reviews := func(h *harness) []*sdk.SourceCodePullRequestReview {
+		var found []*sdk.SourceCodePullRequestReview
+		collect(h.pipe, &found)
+		h.pipe.Reset()
+		return found
+	}
+	h := newHarness(t)
+	if err := h.webhook(t, "testdata/bitbucket/webhooks/pullrequest_changes_request_created.json"); err != nil {
+		t.Fatal(err)
+	}
+	created := reviews(h)
+	if len(created) != 1 || !created[0].Active || created[0].State != sdk.SourceCodePullRequestReviewStateChangesRequested || created[0].UserRefID != "bob-id" {
+		t.Fatalf("expected one active changes requested review from bob, got %v", created)
+	}
+	if err := h.webhook(t, "testdata/bitbucket/webhooks/pullrequest_changes_request_removed.json"); err != nil {
+		t.Fatal(err)
+	}
+	if removed := reviews(h); len(removed) != 1 || removed[0].Active || removed[0].RefID != created[0].RefID {
+		t.Errorf("expected the review to be deactivated once, got %v", removed)
+	}
+
+	// a changes request from before they were saved is still deactivated
+	h = newHarness(t)
+	if err := h.webhook(t, "testdata/bitbucket/webhooks/pullrequest_changes_request_removed.json"); err != nil {
+		t.Fatal(err)
+	}
+	if removed := reviews(h); len(removed) != 1 || removed[0].Active || removed[0].RefID != created[0].RefID {
+		t.Errorf("expected the unsaved review to be deactivated, got %v", removed)
+	}
+}
+
+func TestWebHookPullRequestCommentDeleted(t *testing.T) {
+	h := newHarness(t)
+	if err := h.webhook(t, "testdata/bitbucket/webhooks/pullrequest_comment_deleted.json"); err != nil {
+		t.Fatal(err)
+	}
+	var comments []*sdk.SourceCodePullRequestComment
+	collect(h.pipe, &comments)
+	if len(comments) != 1 || comments[0].RefID != "10" || comments[0].Active {
+		t.Errorf("expected inactive comment 10, got %v", comments)
+	}
+}
+
+func TestWebHookMissingEvent(t *testing.T) {
+	h := newHarness(t)
+	if err := h.integration.ProcessWebHook(h.logger, h.config, h.state, h.pipe, testCustomerID, testIntegrationInstanceID, "", []byte(`{}`)); err == nil {
+		t.Error("expected an error without an event")
+	}
+}
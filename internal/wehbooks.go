@@ -12,7 +12,7 @@ import (
 	"github.com/pinpt/bitbucket/internal/api"
 )
 
-const webhookVersion = "1" // change this to have the webhook uninstalled and reinstalled new
+const webhookVersion = "2" // change this to have the webhook uninstalled and reinstalled new
 
 const (
 	// webHookRepoPush                  api.WebHookEventName = "repo:push"
@@ -34,6 +34,9 @@ const (
 	webHookPullrequestFulfilled  api.WebHookEventName = "pullrequest:fulfilled"
 	webHookPullrequestRejected   api.WebHookEventName = "pullrequest:rejected"
 
+	webHookPullrequestChangesRequestCreated api.WebHookEventName = "pullrequest:changes_request_created"
+	webHookPullrequestChangesRequestRemoved api.WebHookEventName = "pullrequest:changes_request_removed"
+
 	webHookPullrequestCommentCreated api.WebHookEventName = "pullrequest:comment_created"
 	webHookPullrequestCommentUpdated api.WebHookEventName = "pullrequest:comment_updated"
 	webHookPullrequestCommentDeleted api.WebHookEventName = "pullrequest:comment_deleted"
@@ -55,6 +58,8 @@ var webhookEvents = []api.WebHookEventName{
 	webHookPullrequestUnapproved,
 	webHookPullrequestFulfilled,
 	webHookPullrequestRejected,
+	webHookPullrequestChangesRequestCreated,
+	webHookPullrequestChangesRequestRemoved,
 	webHookPullrequestCommentCreated,
 	webHookPullrequestCommentUpdated,
 	webHookPullrequestCommentDeleted,
@@ -62,39 +67,32 @@ var webhookEvents = []api.WebHookEventName{
 
 // WebHook is called when a webhook is received on behalf of the integration
 func (g *BitBucketIntegration) WebHook(webhook sdk.WebHook) error {
-	logger := webhook.Logger()
 	vals, err := url.ParseQuery(webhook.URL())
 	if err != nil {
 		return err
 	}
-	data := webhook.Bytes()
-	pipe := webhook.Pipe()
-	customerID := webhook.CustomerID()
-	integrationInstanceID := webhook.IntegrationInstanceID()
-	config := webhook.Config()
-	state := webhook.State()
-	name := vals.Get("event")
+	return g.ProcessWebHook(webhook.Logger(), webhook.Config(), webhook.State(), webhook.Pipe(), webhook.CustomerID(), webhook.IntegrationInstanceID(), vals.Get("event"), webhook.Bytes())
+}
 
+// ProcessWebHook handles the payload of a webhook event, it's separate from WebHook so that captured payloads can be replayed
+func (g *BitBucketIntegration) ProcessWebHook(logger sdk.Logger, config sdk.Config, state sdk.State, pipe sdk.Pipe, customerID, integrationInstanceID, name string, data []byte) error {
 	if name == "" {
 		return errors.New("missing `event` from query")
 	}
-	var creds sdk.WithHTTPOption
-	if config.BasicAuth != nil {
-		sdk.LogInfo(logger, "using basic auth")
-		creds = sdk.WithBasicAuth(
-			config.BasicAuth.Username,
-			config.BasicAuth.Password,
-		)
-	} else {
-		sdk.LogInfo(logger, "using oauth2")
-		creds = sdk.WithOAuth2Refresh(
-			g.manager, g.refType,
-			config.OAuth2Auth.AccessToken,
-			*config.OAuth2Auth.RefreshToken,
-		)
+	creds, err := g.getCredentials(logger, config)
+	if err != nil {
+		return err
 	}
 
-	a := api.New(logger, g.httpClient, state, pipe, customerID, integrationInstanceID, g.refType, creds)
+	a := api.New(logger, g.httpClient, state, pipe, customerID, integrationInstanceID, g.refType, creds.opt)
+	a.SetContext(g.context())
+	bots, err := newBotDetector(config)
+	if err != nil {
+		return err
+	}
+	a.SetBotDetector(bots)
+	a.SetIssueKeyExtractor(newIssueKeyExtractor(config))
+	a.SetCommitterLookup(fetchCommitters(config))
 
 	eventname := api.WebHookEventName(name)
 	switch eventname {
@@ -111,10 +109,12 @@ func (g *BitBucketIntegration) WebHook(webhook sdk.WebHook) error {
 		}
 
 	case webHookPullrequestCreated, webHookPullrequestUpdated, webHookPullrequestApproved,
-		webHookPullrequestUnapproved, webHookPullrequestFulfilled, webHookPullrequestRejected:
+		webHookPullrequestUnapproved, webHookPullrequestFulfilled, webHookPullrequestRejected,
+		webHookPullrequestChangesRequestCreated, webHookPullrequestChangesRequestRemoved:
 		var raw struct {
-			PullRequest api.PullRequestResponse `json:"pullrequest"`
-			Repository  api.RepoResponse        `json:"repository"`
+			PullRequest    api.PullRequestResponse    `json:"pullrequest"`
+			Repository     api.RepoResponse           `json:"repository"`
+			ChangesRequest api.ChangesRequestResponse `json:"changes_request"`
 		}
 		if err := json.Unmarshal(data, &raw); err != nil {
 			return err
@@ -129,7 +129,18 @@ func (g *BitBucketIntegration) WebHook(webhook sdk.WebHook) error {
 			return err
 		}
 
-		if err := a.ExtractPullRequestReview(raw.PullRequest, pr.RepoID); err != nil {
+		if err := a.SendPullRequestUsers(raw.PullRequest); err != nil {
+			return err
+		}
+
+		if eventname == webHookPullrequestChangesRequestRemoved {
+			// the pr no longer has the changes request, so it's deactivated when the reviews are extracted
+			if err := a.TrackChangesRequest(raw.PullRequest, raw.ChangesRequest, raw.Repository.UUID); err != nil {
+				return err
+			}
+		}
+
+		if err := a.ExtractPullRequestReview(raw.PullRequest, raw.Repository.UUID); err != nil {
 			return fmt.Errorf("error getting reviews: %w", err)
 		}
 
@@ -144,7 +155,10 @@ func (g *BitBucketIntegration) WebHook(webhook sdk.WebHook) error {
 		if err := json.Unmarshal(data, &raw); err != nil {
 			return err
 		}
-		prcomment := api.ConvertPullRequestComment(raw.Comment, raw.Repository.UUID, fmt.Sprint(raw.PullRequest.ID), customerID, integrationInstanceID, g.refType)
+		if err := a.SendCommentUsers(raw.Comment); err != nil {
+			return err
+		}
+		prcomment := a.ConvertPullRequestComment(raw.Comment, raw.Repository.FullName, raw.Repository.UUID, fmt.Sprint(raw.PullRequest.ID))
 		if eventname == webHookPullrequestCommentDeleted {
 			prcomment.Active = false
 		}
@@ -163,33 +177,22 @@ func (g *BitBucketIntegration) registerUnregisterWebhooks(instance sdk.Instance,
 	state := instance.State()
 	pipe := instance.Pipe()
 	config := instance.Config()
-	if config.BasicAuth == nil && config.OAuth2Auth == nil {
-		return errors.New("missing auth")
-	}
-	var creds sdk.WithHTTPOption
-	if config.BasicAuth != nil {
-		sdk.LogInfo(logger, "using basic auth")
-		creds = sdk.WithBasicAuth(
-			config.BasicAuth.Username,
-			config.BasicAuth.Password,
-		)
-	} else {
-		sdk.LogInfo(logger, "using oauth2")
-		creds = sdk.WithOAuth2Refresh(
-			g.manager, g.refType,
-			config.OAuth2Auth.AccessToken,
-			*config.OAuth2Auth.RefreshToken,
-		)
+	creds, err := g.getCredentials(logger, config)
+	if err != nil {
+		return err
 	}
-	var concurr int64
-	var ok bool
-	if ok, concurr = config.GetInt("concurrency"); !ok {
-		concurr = 10
+	ctx := g.context()
+	limiter := newLimiter(config)
+	a := api.New(logger, g.httpClient, state, pipe, customerID, integrationID, g.refType, creds.opt)
+	a.SetLimiter(limiter)
+	a.SetContext(ctx)
+	if register {
+		if err := creds.checkScopes(logger, a, webhookScopes); err != nil {
+			return err
+		}
 	}
-	a := api.New(logger, g.httpClient, state, pipe, customerID, integrationID, g.refType, creds)
 	var userid string
-	var err error
-	if register {
+	if register && creds.hasUser() {
 		// only needed for registering webhooks
 		user, err := a.FetchMyUser()
 		if err != nil {
@@ -197,19 +200,25 @@ func (g *BitBucketIntegration) registerUnregisterWebhooks(instance sdk.Instance,
 		}
 		userid = user.UUID
 	}
-	workspaces, err := a.FetchWorkSpaces()
+	workspaces, err := creds.fetchWorkSpaces(a)
 	if err != nil {
 		return err
 	}
 	teams := api.ExtractWorkSpaceIDs(workspaces)
-	repochan := make(chan *sdk.SourceCodeRepo, concurr)
+	repochan := make(chan *sdk.SourceCodeRepo, limiter.Concurrency())
 	errchan := make(chan error)
 
 	webhookManager := g.manager.WebHookManager()
 	go func() {
 		for r := range repochan {
+			// keep draining so fetchRepos doesn't block once stopped
+			if ctx.Err() != nil {
+				continue
+			}
 			client := g.manager.HTTPManager().New("https://bitbucket.org/!api/2.0", nil)
-			a := api.New(logger, client, state, pipe, customerID, integrationID, g.refType, creds)
+			a := api.New(logger, client, state, pipe, customerID, integrationID, g.refType, creds.opt)
+			a.SetLimiter(limiter)
+			a.SetContext(ctx)
 			if register {
 				if err := g.registerWebhooks(logger, r.Name, r.RefID, userid, customerID, integrationID, a, webhookManager); err != nil {
 					webhookManager.Errored(customerID, integrationID, g.refType, r.RefID, sdk.WebHookScopeRepo, err)
@@ -220,15 +229,19 @@ func (g *BitBucketIntegration) registerUnregisterWebhooks(instance sdk.Instance,
 				}
 			}
 		}
-		errchan <- nil
+		errchan <- ctx.Err()
 	}()
+	var fetchErr error
 	for _, team := range teams {
-		if err := a.FetchRepos(team, time.Time{}, repochan); err != nil {
-			return err
+		if fetchErr = creds.fetchRepos(a, team, time.Time{}, repochan); fetchErr != nil {
+			break
 		}
 	}
 	close(repochan)
-	return <-errchan
+	if err := <-errchan; err != nil {
+		return err
+	}
+	return fetchErr
 }
 
 func (g *BitBucketIntegration) registerWebhooks(logger sdk.Logger, reponame, repoid, userid, customerID, integrationID string, a *api.API, webhookManager sdk.WebHookManager) error {